@@ -0,0 +1,298 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schema is applied in order on every start. Each statement must be
+// idempotent so it is safe to run against an existing database.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		description TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS products (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		price INT NOT NULL,
+		stock INT NOT NULL DEFAULT 0,
+		category_id INT REFERENCES categories(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS transactions (
+		id SERIAL PRIMARY KEY,
+		total_amount INT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS transaction_details (
+		id SERIAL PRIMARY KEY,
+		transaction_id INT NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+		product_id INT NOT NULL REFERENCES products(id),
+		quantity INT NOT NULL,
+		subtotal INT NOT NULL
+	)`,
+
+	// Alternate selling units (pack, carton, ...) with a conversion to the base unit.
+	`CREATE TABLE IF NOT EXISTS product_units (
+		id SERIAL PRIMARY KEY,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		name VARCHAR(50) NOT NULL,
+		conversion INT NOT NULL CHECK (conversion > 0),
+		price INT NOT NULL CHECK (price > 0),
+		barcode VARCHAR(64) UNIQUE,
+		UNIQUE (product_id, name)
+	)`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS unit_id INT REFERENCES product_units(id) ON DELETE SET NULL`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS unit_name VARCHAR(50)`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS conversion INT NOT NULL DEFAULT 1`,
+
+	// Append-only stock ledger. Products that predate it get an opening
+	// movement for their current stock so the ledger reconciles from day one.
+	`CREATE TABLE IF NOT EXISTS stock_movements (
+		id BIGSERIAL PRIMARY KEY,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		type VARCHAR(20) NOT NULL CHECK (type IN
+			('opening', 'sale', 'refund', 'purchase_receipt', 'adjustment', 'transfer', 'opname')),
+		quantity INT NOT NULL,
+		balance INT NOT NULL,
+		reference VARCHAR(100),
+		user_name VARCHAR(100),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements (product_id, created_at)`,
+	`INSERT INTO stock_movements (product_id, type, quantity, balance, reference)
+		SELECT p.id, 'opening', p.stock, p.stock, 'ledger backfill'
+		FROM products p
+		WHERE NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.product_id = p.id)`,
+	`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS reason VARCHAR(20)`,
+	`ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS note TEXT`,
+
+	// Stock opname (physical count) sessions. Ledger timestamps use the wall
+	// clock at insert rather than the transaction start so they order
+	// correctly against a count's snapshot.
+	`ALTER TABLE stock_movements ALTER COLUMN created_at SET DEFAULT clock_timestamp()`,
+	`CREATE TABLE IF NOT EXISTS stock_counts (
+		id SERIAL PRIMARY KEY,
+		category_id INT REFERENCES categories(id),
+		status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'posted', 'cancelled')),
+		note TEXT NOT NULL DEFAULT '',
+		started_by VARCHAR(100),
+		started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		posted_by VARCHAR(100),
+		posted_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS stock_count_lines (
+		stock_count_id INT NOT NULL REFERENCES stock_counts(id) ON DELETE CASCADE,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		snapshot INT NOT NULL,
+		counted INT CHECK (counted >= 0),
+		counted_by VARCHAR(100),
+		counted_at TIMESTAMPTZ,
+		PRIMARY KEY (stock_count_id, product_id)
+	)`,
+
+	// Low-stock thresholds. low_stock_alerted_at de-duplicates alerts until
+	// the stock recovers above min_stock.
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS min_stock INT NOT NULL DEFAULT 0 CHECK (min_stock >= 0)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_qty INT NOT NULL DEFAULT 0 CHECK (reorder_qty >= 0)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS low_stock_alerted_at TIMESTAMPTZ`,
+
+	// Suppliers, purchase orders and goods receipts.
+	`CREATE TABLE IF NOT EXISTS suppliers (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(150) NOT NULL,
+		phone VARCHAR(50) NOT NULL DEFAULT '',
+		email VARCHAR(150) NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS purchase_orders (
+		id SERIAL PRIMARY KEY,
+		supplier_id INT NOT NULL REFERENCES suppliers(id),
+		status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN
+			('draft', 'sent', 'partially_received', 'received', 'cancelled')),
+		note TEXT NOT NULL DEFAULT '',
+		created_by VARCHAR(100),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS purchase_order_lines (
+		id SERIAL PRIMARY KEY,
+		purchase_order_id INT NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+		product_id INT NOT NULL REFERENCES products(id),
+		quantity INT NOT NULL CHECK (quantity > 0),
+		received_qty INT NOT NULL DEFAULT 0,
+		cost_price INT NOT NULL CHECK (cost_price >= 0)
+	)`,
+	`CREATE TABLE IF NOT EXISTS goods_receipts (
+		id SERIAL PRIMARY KEY,
+		purchase_order_id INT NOT NULL REFERENCES purchase_orders(id),
+		note TEXT NOT NULL DEFAULT '',
+		received_by VARCHAR(100),
+		received_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS goods_receipt_lines (
+		id SERIAL PRIMARY KEY,
+		goods_receipt_id INT NOT NULL REFERENCES goods_receipts(id) ON DELETE CASCADE,
+		purchase_order_line_id INT NOT NULL REFERENCES purchase_order_lines(id),
+		quantity INT NOT NULL CHECK (quantity > 0)
+	)`,
+
+	// Cost price per base unit (moving weighted average), snapshotted on
+	// every sale for COGS.
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS cost_price INT NOT NULL DEFAULT 0 CHECK (cost_price >= 0)`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS cost_price INT NOT NULL DEFAULT 0`,
+
+	// Batches with expiry dates, consumed first-expiry-first-out. For a
+	// tracked product the batch quantities never exceed its stock.
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS track_batches BOOLEAN NOT NULL DEFAULT false`,
+	`CREATE TABLE IF NOT EXISTS product_batches (
+		id SERIAL PRIMARY KEY,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		lot_number VARCHAR(64) NOT NULL,
+		expiry_date DATE,
+		quantity INT NOT NULL CHECK (quantity >= 0),
+		received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (product_id, lot_number)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_product_batches_expiry ON product_batches (expiry_date) WHERE quantity > 0`,
+	`CREATE TABLE IF NOT EXISTS transaction_detail_batches (
+		transaction_detail_id INT NOT NULL REFERENCES transaction_details(id) ON DELETE CASCADE,
+		batch_id INT NOT NULL REFERENCES product_batches(id),
+		quantity INT NOT NULL CHECK (quantity > 0),
+		PRIMARY KEY (transaction_detail_id, batch_id)
+	)`,
+	`ALTER TABLE goods_receipt_lines ADD COLUMN IF NOT EXISTS batch_id INT REFERENCES product_batches(id)`,
+
+	// Keyset pagination indexes for the product list sorts.
+	`CREATE INDEX IF NOT EXISTS idx_products_name_id ON products (name, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_price_id ON products (price, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_stock_id ON products (stock, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_category ON products (category_id)`,
+
+	// Products without a category are a supported state.
+	`ALTER TABLE products ALTER COLUMN category_id DROP NOT NULL`,
+
+	// Product search: full-text over name, brand, SKU and barcode plus
+	// trigram indexes for typo-tolerant matching.
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS sku VARCHAR(64)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS barcode VARCHAR(64)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS brand VARCHAR(100)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_sku ON products (sku)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_barcode ON products (barcode)`,
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', COALESCE(name, '')), 'A') ||
+		setweight(to_tsvector('simple', COALESCE(brand, '')), 'B') ||
+		setweight(to_tsvector('simple', COALESCE(sku, '') || ' ' || COALESCE(barcode, '')), 'C')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_brand_trgm ON products USING GIN (brand gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_categories_name_trgm ON categories USING GIN (name gin_trgm_ops)`,
+
+	// Row versions for optimistic concurrency, served as ETags.
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+	`ALTER TABLE categories ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`,
+
+	// Soft delete: archived products stay referenced by past transactions.
+	// SKUs and barcodes only need to be unique among active products.
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`ALTER TABLE categories ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`DROP INDEX IF EXISTS idx_products_sku`,
+	`DROP INDEX IF EXISTS idx_products_barcode`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_active_sku ON products (sku) WHERE deleted_at IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_active_barcode ON products (barcode) WHERE deleted_at IS NULL`,
+
+	// Category tree. category_paths gives every category reachable from a
+	// root its path of ancestors, root first, including itself.
+	`ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES categories(id)`,
+	`CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories (parent_id)`,
+	`CREATE OR REPLACE VIEW category_paths AS
+		WITH RECURSIVE tree AS (
+			SELECT id, ARRAY[id] AS path_ids, ARRAY[name::text] AS path_names
+			FROM categories WHERE parent_id IS NULL
+			UNION ALL
+			SELECT c.id, t.path_ids || c.id, t.path_names || c.name::text
+			FROM categories c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT id, path_ids, path_names, cardinality(path_ids) AS depth FROM tree`,
+
+	// Category names are unique among active categories, ignoring case and
+	// surrounding spaces. Existing duplicates get their ID appended first.
+	`UPDATE categories SET name = btrim(name) WHERE name <> btrim(name)`,
+	`UPDATE categories c SET name = LEFT(c.name, 90) || ' (' || c.id || ')'
+		WHERE c.deleted_at IS NULL AND EXISTS (
+			SELECT 1 FROM categories o
+			WHERE o.deleted_at IS NULL AND o.id < c.id AND lower(o.name) = lower(c.name))`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_active_name ON categories (lower(btrim(name)))
+		WHERE deleted_at IS NULL`,
+
+	// Each sale remembers the product's category path at the time, so that
+	// recategorising a product doesn't rewrite sales history. An empty path
+	// means uncategorized; NULL means not snapshotted yet, which only older
+	// sales are, and those get the category their product has now.
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS category_id INT`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS category_name VARCHAR(100)`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS category_path_ids INT[]`,
+	`ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS category_path_names TEXT[]`,
+	`UPDATE transaction_details td SET
+			category_id = p.category_id,
+			category_name = c.name,
+			category_path_ids = COALESCE(cp.path_ids, '{}'),
+			category_path_names = COALESCE(cp.path_names, '{}')
+		FROM products p
+			LEFT JOIN categories c ON c.id = p.category_id
+			LEFT JOIN category_paths cp ON cp.id = p.category_id
+		WHERE td.product_id = p.id AND td.category_path_ids IS NULL`,
+
+	// Outlets keep their own business day: it starts at day_cutover_hour in
+	// the outlet's timezone, so a café open past midnight books those sales
+	// to the day before. Reports select transactions by created_at ranges.
+	`CREATE TABLE IF NOT EXISTS outlets (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		timezone VARCHAR(64) NOT NULL,
+		day_cutover_hour INT NOT NULL DEFAULT 0 CHECK (day_cutover_hour BETWEEN 0 AND 23)
+	)`,
+	`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS outlet_id INT REFERENCES outlets(id)`,
+	`CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_transactions_outlet ON transactions (outlet_id, created_at)`,
+
+	// deleted_at was first created without a time zone; existing values were
+	// written by NOW() in the session's zone, which the cast assumes too.
+	`DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'products' AND column_name = 'deleted_at'
+					AND data_type = 'timestamp without time zone') THEN
+			ALTER TABLE products ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+		END IF;
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'categories' AND column_name = 'deleted_at'
+					AND data_type = 'timestamp without time zone') THEN
+			ALTER TABLE categories ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+		END IF;
+	END $$`,
+}
+
+// Migrate brings the database schema up to date. It holds an advisory lock
+// so that several instances starting at once don't race each other.
+func Migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('kasir-api-migrate'))"); err != nil {
+		return fmt.Errorf("migration lock error: %w", err)
+	}
+
+	for i, stmt := range schema {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d error: %w", i, err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"kasir-api/models"
+	"kasir-api/repositories"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// requestUser identifies who performs a request, for audit trails such as the
+// stock ledger. The POS front end sends the logged-in cashier in X-User.
+func requestUser(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-User"))
+}
+
+// requestRole is the role of the user in X-User, e.g. "cashier" or "supervisor".
+func requestRole(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.Header.Get("X-User-Role")))
+}
+
+// pathSegments returns the non-empty path segments after prefix, e.g.
+// "/api/product/5/units/2" with prefix "/api/product/" gives ["5", "units", "2"].
+func pathSegments(path, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+// queryInt parses an optional integer query parameter; nil means absent.
+func queryInt(q url.Values, key string) (*int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", key)
+	}
+	return &n, nil
+}
+
+// mergePatchBody accepts PATCH bodies sent as application/merge-patch+json,
+// or as plain JSON by clients that don't set the patch media type.
+func mergePatchBody(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mediaType == "application/merge-patch+json" || mediaType == "application/json")
+}
+
+// etag is the entity tag of a row at the given version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// productETag tags a product as served. Its stock and its category's name
+// and path change without the product being edited, so after the version
+// the tag carries a hash of the body; If-Match only looks at the version.
+func productETag(product *models.Product) string {
+	body, _ := json.Marshal(product)
+	return fmt.Sprintf(`"%d-%08x"`, product.Version, crc32.ChecksumIEEE(body))
+}
+
+// requireIfMatch returns the version named in If-Match that a write is
+// conditional on, answering 428 when the header is missing. "*" gives
+// repositories.AnyVersion; anything that is not a strong version tag gives
+// 0, which matches none. What follows a "-" in the tag is ignored.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		http.Error(w, "If-Match header is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	if h == "*" {
+		return repositories.AnyVersion, true
+	}
+
+	if len(h) < 2 || h[0] != '"' || h[len(h)-1] != '"' {
+		return 0, true
+	}
+	tag, _, _ := strings.Cut(h[1:len(h)-1], "-")
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 1 {
+		return 0, true
+	}
+	return version, true
+}
+
+// notModified reports whether If-None-Match already names tag, in which
+// case a GET can be answered with 304.
+func notModified(r *http.Request, tag string) bool {
+	h := r.Header.Get("If-None-Match")
+	if h == "" {
+		return false
+	}
+
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDuplicate answers a unique constraint violation with a 409 that names
+// the clashing field, so the front end can point at it.
+func writeDuplicate(w http.ResponseWriter, dup *repositories.DuplicateError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": dup.Error(),
+		"field": dup.Field,
+		"value": dup.Value,
+	})
+}
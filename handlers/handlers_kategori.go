@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"kasir-api/models"
+	"kasir-api/repositories"
 	"kasir-api/services"
 )
 
@@ -29,8 +32,11 @@ func (h *CategoryHandler) HandleCategories(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// GetAll lists categories; include_deleted=true adds deleted ones and
+// with_stats=true adds product counts and stock value.
 func (h *CategoryHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	categories, err := h.service.GetAll()
+	q := r.URL.Query()
+	categories, err := h.service.GetAll(q.Get("include_deleted") == "true", q.Get("with_stats") == "true")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -40,6 +46,24 @@ func (h *CategoryHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(categories)
 }
 
+// GetTree serves GET /api/category/tree, the categories nested under their
+// parents.
+func (h *CategoryHandler) GetTree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tree, err := h.service.Tree()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
 func (h *CategoryHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var category models.Category
 	err := json.NewDecoder(r.Body).Decode(&category)
@@ -50,21 +74,34 @@ func (h *CategoryHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	err = h.service.Create(&category)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeCategoryError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(category.Version))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(category)
 }
 
 func (h *CategoryHandler) HandleCategoryByID(w http.ResponseWriter, r *http.Request) {
+	parts := pathSegments(r.URL.Path, "/api/category/")
+	if len(parts) == 1 && parts[0] == "tree" {
+		h.GetTree(w, r)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "restore" {
+		h.Restore(w, r, parts)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.GetByID(w, r)
 	case http.MethodPut:
 		h.Update(w, r)
+	case http.MethodPatch:
+		h.Patch(w, r)
 	case http.MethodDelete:
 		h.Delete(w, r)
 	default:
@@ -80,11 +117,26 @@ func (h *CategoryHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	category, err := h.service.GetByID(id)
+	withStats := r.URL.Query().Get("with_stats") == "true"
+	category, err := h.service.GetByID(id, withStats)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if category.DeletedAt != nil && r.URL.Query().Get("include_deleted") != "true" {
+		http.Error(w, repositories.ErrCategoryNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Stats change with every sale, so only the plain category is cacheable
+	if !withStats {
+		tag := etag(category.Version)
+		w.Header().Set("ETag", tag)
+		if notModified(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(category)
@@ -98,6 +150,11 @@ func (h *CategoryHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var category models.Category
 	err = json.NewDecoder(r.Body).Decode(&category)
 	if err != nil {
@@ -106,12 +163,50 @@ func (h *CategoryHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	category.ID = id
+	category.Version = version
 	err = h.service.Update(&category)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeCategoryError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(category.Version))
+	json.NewEncoder(w).Encode(category)
+}
+
+// Patch changes only the fields present in a JSON Merge Patch body.
+func (h *CategoryHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/category/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if !mergePatchBody(r) {
+		http.Error(w, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	category, err := h.service.Patch(id, version, patch)
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(category.Version))
 	json.NewEncoder(w).Encode(category)
 }
 
@@ -123,14 +218,77 @@ func (h *CategoryHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.service.Delete(id)
+	// A category with products needs ?reassign_to=<id> to move them, or
+	// ?force=true to leave them uncategorized.
+	reassignTo, err := queryInt(r.URL.Query(), "reassign_to")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	affected, err := h.service.Delete(id, reassignTo, force, version)
+	if err != nil {
+		writeCategoryError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Category delete successfully",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":           "Category delete successfully",
+		"products_affected": affected,
+		"reassigned_to":     reassignTo,
+		"force":             force,
 	})
 }
+
+// Restore serves POST /api/category/{id}/restore, un-deleting a category.
+func (h *CategoryHandler) Restore(w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	category, err := h.service.Restore(id)
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(category.Version))
+	json.NewEncoder(w).Encode(category)
+}
+
+// writeCategoryError answers an error from the category service with the
+// matching status.
+func writeCategoryError(w http.ResponseWriter, err error) {
+	var verr *services.ValidationError
+	var dup *repositories.DuplicateError
+	switch {
+	case errors.As(err, &dup):
+		writeDuplicate(w, dup)
+	case errors.As(err, &verr), errors.Is(err, repositories.ErrCategoryCycle),
+		errors.Is(err, repositories.ErrInactiveCategory):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repositories.ErrCategoryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repositories.ErrCategoryInUse), errors.Is(err, repositories.ErrNotDeleted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, repositories.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
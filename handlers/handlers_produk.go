@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"kasir-api/models"
+	"kasir-api/repositories"
 	"kasir-api/services"
 )
 
@@ -29,16 +32,84 @@ func (h *ProductHandler) HandleProducts(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// GetAll lists products a page at a time. Query parameters: name,
+// category_id (or category=none for uncategorized), min_price, max_price,
+// min_stock, max_stock, in_stock=true, include_deleted=true,
+// sort=id|name|price|stock, order=asc|desc, limit and cursor.
 func (h *ProductHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get("name")
-	products, err := h.service.GetAll(name)
+	filter, err := parseProductFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.service.GetAll(filter)
+	if errors.Is(err, repositories.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(products)
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseProductFilter(r *http.Request) (models.ProductFilter, error) {
+	q := r.URL.Query()
+	filter := models.ProductFilter{
+		Name:    q.Get("name"),
+		Sort:    q.Get("sort"),
+		Cursor:  q.Get("cursor"),
+		InStock: q.Get("in_stock") == "true",
+	}
+	filter.IncludeDeleted = q.Get("include_deleted") == "true"
+
+	switch filter.Sort {
+	case "", "id", "name", "price", "stock":
+	default:
+		return filter, errors.New("sort must be one of id, name, price, stock")
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, errors.New("order must be asc or desc")
+	}
+
+	var err error
+	if filter.CategoryID, err = queryInt(q, "category_id"); err != nil {
+		return filter, err
+	}
+	if q.Get("category") == "none" {
+		filter.Uncategorized = true
+	}
+	if filter.MinPrice, err = queryInt(q, "min_price"); err != nil {
+		return filter, err
+	}
+	if filter.MaxPrice, err = queryInt(q, "max_price"); err != nil {
+		return filter, err
+	}
+	if filter.MinStock, err = queryInt(q, "min_stock"); err != nil {
+		return filter, err
+	}
+	if filter.MaxStock, err = queryInt(q, "max_stock"); err != nil {
+		return filter, err
+	}
+
+	limit, err := queryInt(q, "limit")
+	if err != nil {
+		return filter, err
+	}
+	if limit != nil {
+		filter.Limit = *limit
+	}
+
+	return filter, nil
 }
 
 func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -50,34 +121,17 @@ func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if product.CategoryID == nil {
-		if product.Category != nil && product.Category.ID > 0 {
-			product.CategoryID = &product.Category.ID
-		} else {
-			http.Error(w, "Category ID is required", http.StatusBadRequest)
-			return
-		}
-	}
-
-	if *product.CategoryID <= 0 {
-		http.Error(w, "Category ID must be greater than 0", http.StatusBadRequest)
-		return
+	// A product without a category is allowed and listed as uncategorized.
+	if product.CategoryID == nil && product.Category != nil && product.Category.ID > 0 {
+		product.CategoryID = &product.Category.ID
 	}
 
-	if product.Name == "" {
-		http.Error(w, "Product name is required", http.StatusBadRequest)
-		return
-	}
-	if product.Price <= 0 {
-		http.Error(w, "Price must be greater than 0", http.StatusBadRequest)
-		return
-	}
-	if product.Stock < 0 {
-		http.Error(w, "Stock cannot be negative", http.StatusBadRequest)
+	err = h.service.Create(&product, requestUser(r))
+	var dup *repositories.DuplicateError
+	if errors.As(err, &dup) {
+		writeDuplicate(w, dup)
 		return
 	}
-
-	err = h.service.Create(&product)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -90,16 +144,49 @@ func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(createdProduct))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(createdProduct)
 }
 
 func (h *ProductHandler) HandleProductByID(w http.ResponseWriter, r *http.Request) {
+	parts := pathSegments(r.URL.Path, "/api/product/")
+	if len(parts) == 1 && parts[0] == "low-stock" {
+		h.GetLowStock(w, r)
+		return
+	}
+	if len(parts) == 1 && parts[0] == "search" {
+		h.Search(w, r)
+		return
+	}
+	if len(parts) > 1 && parts[1] == "units" {
+		h.HandleUnits(w, r, parts)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "movements" {
+		h.GetMovements(w, r, parts)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "batches" {
+		h.HandleBatches(w, r, parts)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "stock-adjustments" {
+		h.AdjustStock(w, r, parts)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "restore" {
+		h.Restore(w, r, parts)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.GetByID(w, r)
 	case http.MethodPut:
 		h.Update(w, r)
+	case http.MethodPatch:
+		h.Patch(w, r)
 	case http.MethodDelete:
 		h.Delete(w, r)
 	default:
@@ -117,7 +204,18 @@ func (h *ProductHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 
 	product, err := h.service.GetByID(id)
 	if err != nil {
-		http.Error(w, "Product not found or has no category", http.StatusNotFound)
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if product.DeletedAt != nil && r.URL.Query().Get("include_deleted") != "true" {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	tag := productETag(product)
+	w.Header().Set("ETag", tag)
+	if notModified(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -133,6 +231,11 @@ func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var product models.Product
 	err = json.NewDecoder(r.Body).Decode(&product)
 	if err != nil {
@@ -141,22 +244,27 @@ func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product.ID = id
+	product.Version = version
 
-	if product.CategoryID == nil {
-		if product.Category != nil && product.Category.ID > 0 {
-			product.CategoryID = &product.Category.ID
-		} else {
-			http.Error(w, "Category ID is required", http.StatusBadRequest)
-			return
-		}
+	// A product without a category is allowed and listed as uncategorized.
+	if product.CategoryID == nil && product.Category != nil && product.Category.ID > 0 {
+		product.CategoryID = &product.Category.ID
 	}
 
-	if *product.CategoryID <= 0 {
-		http.Error(w, "Category ID must be greater than 0", http.StatusBadRequest)
+	err = h.service.Update(&product)
+	var dup *repositories.DuplicateError
+	if errors.As(err, &dup) {
+		writeDuplicate(w, dup)
+		return
+	}
+	if errors.Is(err, repositories.ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
 		return
 	}
-
-	err = h.service.Update(&product)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -169,9 +277,65 @@ func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(updatedProduct))
 	json.NewEncoder(w).Encode(updatedProduct)
 }
 
+// Patch changes only the fields present in a JSON Merge Patch body; null
+// clears sku, barcode, brand or category_id.
+func (h *ProductHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/product/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if !mergePatchBody(r) {
+		http.Error(w, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.service.Patch(id, version, patch)
+	var verr *services.ValidationError
+	var dup *repositories.DuplicateError
+	if errors.As(err, &verr) || errors.Is(err, repositories.ErrInactiveCategory) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.As(err, &dup) {
+		writeDuplicate(w, dup)
+		return
+	}
+	if errors.Is(err, repositories.ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(product))
+	json.NewEncoder(w).Encode(product)
+}
+
 func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/product/")
 	id, err := strconv.Atoi(idStr)
@@ -180,7 +344,20 @@ func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.service.Delete(id)
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	err = h.service.Delete(id, version)
+	if errors.Is(err, repositories.ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -191,3 +368,316 @@ func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		"message": "Product deleted successfully",
 	})
 }
+
+// Restore serves POST /api/product/{id}/restore, un-deleting a product.
+func (h *ProductHandler) Restore(w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.service.Restore(id)
+	if errors.Is(err, repositories.ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var dup *repositories.DuplicateError
+	if errors.As(err, &dup) {
+		writeDuplicate(w, dup)
+		return
+	}
+	if errors.Is(err, repositories.ErrNotDeleted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", productETag(product))
+	json.NewEncoder(w).Encode(product)
+}
+
+// HandleUnits serves /api/product/{id}/units and /api/product/{id}/units/{unitID}.
+func (h *ProductHandler) HandleUnits(w http.ResponseWriter, r *http.Request, parts []string) {
+	productID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		h.GetUnits(w, r, productID)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		h.CreateUnit(w, r, productID)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		unitID, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.Error(w, "Invalid unit ID", http.StatusBadRequest)
+			return
+		}
+		h.DeleteUnit(w, r, productID, unitID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ProductHandler) GetUnits(w http.ResponseWriter, r *http.Request, productID int) {
+	units, err := h.service.GetUnits(productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(units)
+}
+
+func (h *ProductHandler) CreateUnit(w http.ResponseWriter, r *http.Request, productID int) {
+	var unit models.ProductUnit
+	err := json.NewDecoder(r.Body).Decode(&unit)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	unit.ProductID = productID
+	unit.Name = strings.TrimSpace(unit.Name)
+	unit.Barcode = strings.TrimSpace(unit.Barcode)
+
+	if unit.Name == "" {
+		http.Error(w, "Unit name is required", http.StatusBadRequest)
+		return
+	}
+	if unit.Conversion <= 0 {
+		http.Error(w, "Conversion must be greater than 0", http.StatusBadRequest)
+		return
+	}
+	if unit.Price <= 0 {
+		http.Error(w, "Price must be greater than 0", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.service.GetByID(productID); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.service.CreateUnit(&unit)
+	var dup *repositories.DuplicateError
+	if errors.As(err, &dup) {
+		writeDuplicate(w, dup)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(unit)
+}
+
+func (h *ProductHandler) DeleteUnit(w http.ResponseWriter, r *http.Request, productID, unitID int) {
+	err := h.service.DeleteUnit(productID, unitID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Unit deleted successfully",
+	})
+}
+
+func (h *ProductHandler) GetMovements(w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	productID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	ledger, err := h.service.GetStockLedger(productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ledger)
+}
+
+func (h *ProductHandler) AdjustStock(w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	productID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var adj models.StockAdjustment
+	err = json.NewDecoder(r.Body).Decode(&adj)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	movement, err := h.service.AdjustStock(productID, adj, requestUser(r), requestRole(r))
+	if errors.Is(err, services.ErrSupervisorRequired) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, repositories.ErrInsufficientStock) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(movement)
+}
+
+// Search serves /api/product/search?q=&limit=.
+func (h *ProductHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := queryInt(r.URL.Query(), "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == nil {
+		limit = new(int)
+	}
+
+	results, err := h.service.Search(q, *limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (h *ProductHandler) GetLowStock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	products, err := h.service.GetLowStock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(products)
+}
+
+// HandleBatches serves /api/product/{id}/batches.
+func (h *ProductHandler) HandleBatches(w http.ResponseWriter, r *http.Request, parts []string) {
+	productID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		batches, err := h.service.GetBatches(productID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batches)
+	case http.MethodPost:
+		var batch models.ProductBatch
+		err := json.NewDecoder(r.Body).Decode(&batch)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		batch.ProductID = productID
+		batch.LotNumber = strings.TrimSpace(batch.LotNumber)
+		err = h.service.CreateBatch(&batch)
+		if errors.Is(err, repositories.ErrInsufficientStock) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(batch)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleExpiringReport lists batches expiring within ?days= (default 30).
+func (h *ProductHandler) HandleExpiringReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "days must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	batches, err := h.service.GetExpiringBatches(days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batches)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"kasir-api/models"
+	"kasir-api/repositories"
+	"kasir-api/services"
+)
+
+type StockCountHandler struct {
+	service *services.StockCountService
+}
+
+func NewStockCountHandler(service *services.StockCountService) *StockCountHandler {
+	return &StockCountHandler{service: service}
+}
+
+func (h *StockCountHandler) HandleStockCounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetAll(w, r)
+	case http.MethodPost:
+		h.Start(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleStockCountByID serves /api/stock-count/{id} and its items, post and
+// cancel actions.
+func (h *StockCountHandler) HandleStockCountByID(w http.ResponseWriter, r *http.Request) {
+	parts := pathSegments(r.URL.Path, "/api/stock-count/")
+	if len(parts) == 0 || len(parts) > 2 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid stock count ID", http.StatusBadRequest)
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		h.GetByID(w, r, id)
+	case action == "items" && r.Method == http.MethodPost:
+		h.SubmitItems(w, r, id)
+	case action == "post" && r.Method == http.MethodPost:
+		h.Post(w, r, id)
+	case action == "cancel" && r.Method == http.MethodPost:
+		h.Cancel(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *StockCountHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.service.GetAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
+
+func (h *StockCountHandler) Start(w http.ResponseWriter, r *http.Request) {
+	var count models.StockCount
+	err := json.NewDecoder(r.Body).Decode(&count)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	count.StartedBy = requestUser(r)
+	err = h.service.Start(&count)
+	if errors.Is(err, repositories.ErrInactiveCategory) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.service.GetByID(count.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve stock count: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *StockCountHandler) GetByID(w http.ResponseWriter, r *http.Request, id int) {
+	count, err := h.service.GetByID(id)
+	if errors.Is(err, repositories.ErrStockCountNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(count)
+}
+
+func (h *StockCountHandler) SubmitItems(w http.ResponseWriter, r *http.Request, id int) {
+	var req models.StockCountSubmission
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.SubmitItems(id, req.Items, requestUser(r))
+	if err != nil {
+		writeStockCountError(w, err)
+		return
+	}
+
+	h.GetByID(w, r, id)
+}
+
+func (h *StockCountHandler) Post(w http.ResponseWriter, r *http.Request, id int) {
+	count, err := h.service.Post(id, requestUser(r))
+	if err != nil {
+		writeStockCountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(count)
+}
+
+func (h *StockCountHandler) Cancel(w http.ResponseWriter, r *http.Request, id int) {
+	err := h.service.Cancel(id)
+	if err != nil {
+		writeStockCountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Stock count cancelled successfully",
+	})
+}
+
+// writeStockCountError answers a failed stock count action: 404 for an unknown
+// count, 409 once it is closed, and 400 for anything else the count refused.
+func writeStockCountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repositories.ErrStockCountNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repositories.ErrStockCountClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
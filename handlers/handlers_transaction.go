@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"kasir-api/models"
+	"kasir-api/repositories"
 	"kasir-api/services"
 	"net/http"
 )
@@ -32,9 +34,9 @@ func (h *TransactionHandler) Checkout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	transaction, err := h.service.Checkout(req.Items)
+	transaction, err := h.service.Checkout(req.Items, req.OutletID, requestUser(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeCheckoutError(w, err)
 		return
 	}
 
@@ -42,15 +44,39 @@ func (h *TransactionHandler) Checkout(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(transaction)
 }
 
+// reportQuery reads the period a report covers: period=this_week and the
+// like, or start_date and end_date as dates or datetimes; today if neither.
+// outlet_id reports one outlet, in its timezone and business day.
+func reportQuery(r *http.Request) (models.ReportQuery, error) {
+	q := r.URL.Query()
+	outletID, err := queryInt(q, "outlet_id")
+	if err != nil {
+		return models.ReportQuery{}, err
+	}
+
+	return models.ReportQuery{
+		Period:    q.Get("period"),
+		StartDate: q.Get("start_date"),
+		EndDate:   q.Get("end_date"),
+		OutletID:  outletID,
+	}, nil
+}
+
 func (h *TransactionHandler) HandleTodayReport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	report, err := h.service.GetTodayReport()
+	outletID, err := queryInt(r.URL.Query(), "outlet_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.service.GetTodayReport(outletID)
+	if err != nil {
+		writeReportError(w, err)
 		return
 	}
 
@@ -58,29 +84,195 @@ func (h *TransactionHandler) HandleTodayReport(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(report)
 }
 
+// HandleReport serves GET /api/report, sales totals for a period.
+// compare=previous|last_year adds how they changed since that period.
 func (h *TransactionHandler) HandleReport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	startDate := r.URL.Query().Get("start_date")
-	endDate := r.URL.Query().Get("end_date")
+	query, err := reportQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	var report *models.SalesReport
-	var err error
+	report, err := h.service.GetReport(query, r.URL.Query().Get("compare"))
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *TransactionHandler) HandleProductProfitReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	if startDate != "" && endDate != "" {
-		report, err = h.service.GetReportByDateRange(startDate, endDate)
-	} else {
-		report, err = h.service.GetTodayReport()
+	query, err := reportQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	report, err := h.service.GetProductProfit(query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleDailyReport serves GET /api/report/daily, a sales time series with
+// one row per day, or per week or month with group_by.
+func (h *TransactionHandler) HandleDailyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := reportQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.service.GetSalesSeries(query, r.URL.Query().Get("group_by"))
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// HandleHeatmapReport serves GET /api/report/heatmap, transactions and
+// revenue by day of week and hour in the store's or outlet's timezone.
+func (h *TransactionHandler) HandleHeatmapReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := reportQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	heatmap, err := h.service.GetSalesHeatmap(query)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(heatmap)
+}
+
+// HandleProductRankingReport serves GET /api/report/products, the top and
+// bottom products. by=quantity|revenue picks the measure and limit how many
+// products each list holds.
+func (h *TransactionHandler) HandleProductRankingReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := reportQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := 0
+	if limit != nil {
+		n = *limit
+	}
+
+	report, err := h.service.GetProductRanking(query, q.Get("by"), n)
+	if err != nil {
+		writeReportError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(report)
 }
+
+// HandleCategoryReport serves GET /api/report/category, revenue by category.
+// level=N rolls subcategories up to depth N.
+func (h *TransactionHandler) HandleCategoryReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := reportQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	level, err := queryInt(r.URL.Query(), "level")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := 0
+	if level != nil {
+		n = *level
+	}
+
+	report, err := h.service.GetCategorySales(query, n)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func writeCheckoutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repositories.ErrEmptyCheckout),
+		errors.Is(err, repositories.ErrInvalidQuantity),
+		errors.Is(err, repositories.ErrBatchNotTracked):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repositories.ErrProductNotFound),
+		errors.Is(err, repositories.ErrBarcodeNotFound),
+		errors.Is(err, repositories.ErrUnitNotFound),
+		errors.Is(err, repositories.ErrOutletNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repositories.ErrProductDeleted),
+		errors.Is(err, repositories.ErrExpiredBatch),
+		errors.Is(err, repositories.ErrInsufficientBatchStock):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeReportError(w http.ResponseWriter, err error) {
+	var verr *services.ValidationError
+	if errors.As(err, &verr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -1,29 +1,53 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"kasir-api/database"
 	"kasir-api/handlers"
+	"kasir-api/notifier"
 	"kasir-api/repositories"
 	"kasir-api/services"
 
 	"github.com/spf13/viper"
+
+	_ "time/tzdata" // STORE_TIMEZONE must load even without system zoneinfo
 )
 
 type Config struct {
 	Port   string `mapstructure:"PORT"`
 	DBConn string `mapstructure:"DB_CONN"`
+
+	// StockAdjustThreshold is the largest stock adjustment (in base units)
+	// a non-supervisor may make.
+	StockAdjustThreshold int `mapstructure:"STOCK_ADJUST_THRESHOLD"`
+
+	LowStockWebhookURL    string        `mapstructure:"LOW_STOCK_WEBHOOK_URL"`
+	LowStockCheckInterval time.Duration `mapstructure:"LOW_STOCK_CHECK_INTERVAL"`
+
+	// StoreTimezone (an IANA zone) and StoreDayCutoverHour define the
+	// business day for reports not limited to one outlet.
+	StoreTimezone       string `mapstructure:"STORE_TIMEZONE"`
+	StoreDayCutoverHour int    `mapstructure:"STORE_DAY_CUTOVER_HOUR"`
+
+	// ReportMaxRangeDays is the most days a sales report may cover.
+	ReportMaxRangeDays int `mapstructure:"REPORT_MAX_RANGE_DAYS"`
 }
 
 func main() {
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetDefault("STOCK_ADJUST_THRESHOLD", 50)
+	viper.SetDefault("LOW_STOCK_CHECK_INTERVAL", "5m")
+	viper.SetDefault("STORE_TIMEZONE", "Asia/Jakarta")
+	viper.SetDefault("REPORT_MAX_RANGE_DAYS", 366)
 
 	if _, err := os.Stat(".env"); err == nil {
 		viper.SetConfigFile(".env")
@@ -36,6 +60,21 @@ func main() {
 	config := Config{
 		Port:   viper.GetString("PORT"),
 		DBConn: viper.GetString("DB_CONN"),
+
+		StockAdjustThreshold: viper.GetInt("STOCK_ADJUST_THRESHOLD"),
+
+		LowStockWebhookURL:    viper.GetString("LOW_STOCK_WEBHOOK_URL"),
+		LowStockCheckInterval: viper.GetDuration("LOW_STOCK_CHECK_INTERVAL"),
+
+		StoreTimezone:       viper.GetString("STORE_TIMEZONE"),
+		StoreDayCutoverHour: viper.GetInt("STORE_DAY_CUTOVER_HOUR"),
+
+		ReportMaxRangeDays: viper.GetInt("REPORT_MAX_RANGE_DAYS"),
+	}
+
+	storeBusinessDay, err := services.NewBusinessDay(config.StoreTimezone, config.StoreDayCutoverHour)
+	if err != nil {
+		log.Fatalf("Invalid store business day: %v", err)
 	}
 
 	// Setup database
@@ -45,20 +84,50 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := database.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	fmt.Println("✅ Database connected successfully!")
 
 	productRepo := repositories.NewProductRepository(db)
-	productService := services.NewProductService(productRepo)
+	stockRepo := repositories.NewStockRepository(db)
+	productService := services.NewProductService(productRepo, stockRepo, config.StockAdjustThreshold, storeBusinessDay)
 	productHandler := handlers.NewProductHandler(productService)
 
 	categoryRepo := repositories.NewCategoryRepository(db)
 	categoryService := services.NewCategoryService(categoryRepo)
 	categoryHandler := handlers.NewCategoryHandler(categoryService)
 
+	// Low-stock alerts always go to the log, and to a webhook when configured
+	alertNotifier := notifier.Multi{notifier.LogNotifier{}}
+	if config.LowStockWebhookURL != "" {
+		alertNotifier = append(alertNotifier, notifier.NewWebhookNotifier(config.LowStockWebhookURL))
+	}
+	lowStockChecker := services.NewLowStockChecker(productRepo, alertNotifier, config.LowStockCheckInterval)
+	go lowStockChecker.Run(context.Background())
+
+	outletRepo := repositories.NewOutletRepository(db)
+	outletService := services.NewOutletService(outletRepo)
+	outletHandler := handlers.NewOutletHandler(outletService)
+
 	transactionRepo := repositories.NewTransactionRepository(db)
-	transactionService := services.NewTransactionService(transactionRepo)
+	transactionService := services.NewTransactionService(transactionRepo, outletRepo, lowStockChecker, storeBusinessDay,
+		config.ReportMaxRangeDays)
 	transactionHandler := handlers.NewTransactionHandler(transactionService)
 
+	stockCountRepo := repositories.NewStockCountRepository(db)
+	stockCountService := services.NewStockCountService(stockCountRepo)
+	stockCountHandler := handlers.NewStockCountHandler(stockCountService)
+
+	supplierRepo := repositories.NewSupplierRepository(db)
+	supplierService := services.NewSupplierService(supplierRepo)
+	supplierHandler := handlers.NewSupplierHandler(supplierService)
+
+	purchaseOrderRepo := repositories.NewPurchaseOrderRepository(db)
+	purchaseOrderService := services.NewPurchaseOrderService(purchaseOrderRepo)
+	purchaseOrderHandler := handlers.NewPurchaseOrderHandler(purchaseOrderService)
+
 	// Setup routes
 	// PERHATIKAN: Sesuaikan dengan handler Anda
 	// Jika handler menggunakan "product" bukan "produk", sesuaikan
@@ -67,10 +136,24 @@ func main() {
 	http.HandleFunc("/api/category", categoryHandler.HandleCategories)
 	http.HandleFunc("/api/category/", categoryHandler.HandleCategoryByID)
 	http.HandleFunc("/api/checkout", transactionHandler.HandleCheckout)
+	http.HandleFunc("/api/stock-count", stockCountHandler.HandleStockCounts)
+	http.HandleFunc("/api/stock-count/", stockCountHandler.HandleStockCountByID)
+	http.HandleFunc("/api/supplier", supplierHandler.HandleSuppliers)
+	http.HandleFunc("/api/supplier/", supplierHandler.HandleSupplierByID)
+	http.HandleFunc("/api/purchase-order", purchaseOrderHandler.HandlePurchaseOrders)
+	http.HandleFunc("/api/purchase-order/", purchaseOrderHandler.HandlePurchaseOrderByID)
+	http.HandleFunc("/api/outlet", outletHandler.HandleOutlets)
+	http.HandleFunc("/api/outlet/", outletHandler.HandleOutletByID)
 
 	// Report routes
 	http.HandleFunc("/api/report/today", transactionHandler.HandleTodayReport)
 	http.HandleFunc("/api/report", transactionHandler.HandleReport)
+	http.HandleFunc("/api/report/profit", transactionHandler.HandleProductProfitReport)
+	http.HandleFunc("/api/report/daily", transactionHandler.HandleDailyReport)
+	http.HandleFunc("/api/report/products", transactionHandler.HandleProductRankingReport)
+	http.HandleFunc("/api/report/category", transactionHandler.HandleCategoryReport)
+	http.HandleFunc("/api/report/heatmap", transactionHandler.HandleHeatmapReport)
+	http.HandleFunc("/api/report/expiring", productHandler.HandleExpiringReport)
 
 	// Health check endpoint - PERBAIKI sintaks
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
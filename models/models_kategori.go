@@ -1,7 +1,34 @@
 package models
 
+import "time"
+
 type Category struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	ParentID    *int       `json:"parent_id"`
+	Version     int        `json:"version,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+
+	// Children is only filled in the category tree.
+	Children []Category `json:"children,omitempty"`
+
+	// Stats is only filled when asked for.
+	Stats *CategoryStats `json:"stats,omitempty"`
+}
+
+// CategoryStats summarises the active products in a category and all its
+// subcategories. Negative (oversold) stock counts as none.
+type CategoryStats struct {
+	ProductCount int   `json:"product_count"`
+	StockUnits   int   `json:"stock_units"`
+	StockValue   int64 `json:"stock_value"` // at selling price
+	StockCost    int64 `json:"stock_cost"`  // at cost price
+}
+
+// CategoryCrumb is one step of a category path, e.g. Beverages in
+// Beverages > Coffee > Ready-to-drink.
+type CategoryCrumb struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
@@ -0,0 +1,10 @@
+package models
+
+// Outlet is a store location. Its business day starts at DayCutoverHour
+// o'clock in Timezone (an IANA zone name such as Asia/Makassar).
+type Outlet struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	Timezone       string `json:"timezone"`
+	DayCutoverHour int    `json:"day_cutover_hour"`
+}
@@ -1,10 +1,94 @@
 package models
 
+import "time"
+
 type Product struct {
-	ID         int       `json:"id"`
-	Name       string    `json:"name"`
-	Price      int       `json:"price"`
-	Stock      int       `json:"stock"`
-	CategoryID *int      `json:"category_id"`
-	Category   *Category `json:"category"`
+	ID         int           `json:"id"`
+	Name       string        `json:"name"`
+	SKU        string        `json:"sku"`
+	Barcode    string        `json:"barcode"`
+	Brand      string        `json:"brand"`
+	Price      int           `json:"price"`
+	CostPrice  int           `json:"cost_price"`
+	Stock      int           `json:"stock"`
+	MinStock   int           `json:"min_stock"`
+	ReorderQty int           `json:"reorder_qty"`
+	CategoryID *int          `json:"category_id"`
+	Category   *Category     `json:"category"`
+	Units      []ProductUnit `json:"units,omitempty"`
+
+	// CategoryPath leads from the root category down to the product's own.
+	CategoryPath []CategoryCrumb `json:"category_path,omitempty"`
+
+	// TrackBatches products are sold first-expiry-first-out from their
+	// batches, and only from batches that have not expired.
+	TrackBatches bool `json:"track_batches"`
+
+	// Version goes up with every edit to the product or its units; stock
+	// movements leave it alone. The ETag combines it with a checksum of the
+	// served body, so stock changes still show.
+	Version int `json:"version"`
+
+	// DeletedAt is set on archived products, which are hidden from the
+	// catalogue and cannot be sold but keep their sales history.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ProductUnit is an alternate selling unit of a product. Conversion is the
+// number of base units (the unit Stock is counted in) contained in one of it,
+// e.g. a carton of 24 pieces has Conversion 24.
+type ProductUnit struct {
+	ID         int    `json:"id"`
+	ProductID  int    `json:"product_id"`
+	Name       string `json:"name"`
+	Conversion int    `json:"conversion"`
+	Price      int    `json:"price"`
+	Barcode    string `json:"barcode,omitempty"`
+}
+
+// LowStockAlert is emitted once when a product drops to or below its minimum
+// stock, and not again until the stock has recovered above it.
+type LowStockAlert struct {
+	ProductID   int       `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	Stock       int       `json:"stock"`
+	MinStock    int       `json:"min_stock"`
+	ReorderQty  int       `json:"reorder_qty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ProductFilter narrows and orders the product list. Pointer fields are
+// optional bounds; Cursor continues from a previous page.
+type ProductFilter struct {
+	Name          string
+	CategoryID    *int
+	Uncategorized bool
+	MinPrice      *int
+	MaxPrice      *int
+	MinStock      *int
+	MaxStock      *int
+	InStock       bool
+	Sort          string
+	Desc          bool
+	Limit         int
+	Cursor        string
+
+	// IncludeDeleted lists archived products as well.
+	IncludeDeleted bool
+}
+
+// ProductPage is one page of the product list. NextCursor is empty on the
+// last page; TotalCount counts all products matching the filter.
+type ProductPage struct {
+	Items      []Product `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	TotalCount int       `json:"total_count"`
+}
+
+// ProductSearchResult is a ranked search hit. Highlights hold the matched
+// fields with matches wrapped in <mark></mark>.
+type ProductSearchResult struct {
+	Product
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
 }
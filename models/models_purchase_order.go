@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Purchase order statuses. A draft can still be edited; once sent it can only
+// be received against or cancelled.
+const (
+	POStatusDraft             = "draft"
+	POStatusSent              = "sent"
+	POStatusPartiallyReceived = "partially_received"
+	POStatusReceived          = "received"
+	POStatusCancelled         = "cancelled"
+)
+
+type PurchaseOrder struct {
+	ID         int                 `json:"id"`
+	SupplierID int                 `json:"supplier_id"`
+	Supplier   *Supplier           `json:"supplier,omitempty"`
+	Status     string              `json:"status"`
+	Note       string              `json:"note"`
+	CreatedBy  string              `json:"created_by,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+	Lines      []PurchaseOrderLine `json:"lines"`
+	Receipts   []GoodsReceipt      `json:"receipts,omitempty"`
+}
+
+// PurchaseOrderLine orders Quantity base units of a product at CostPrice each.
+type PurchaseOrderLine struct {
+	ID          int    `json:"id"`
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name,omitempty"`
+	Quantity    int    `json:"quantity"`
+	ReceivedQty int    `json:"received_qty"`
+	CostPrice   int    `json:"cost_price"`
+}
+
+type GoodsReceipt struct {
+	ID         int                `json:"id"`
+	Note       string             `json:"note"`
+	ReceivedBy string             `json:"received_by,omitempty"`
+	ReceivedAt time.Time          `json:"received_at"`
+	Lines      []GoodsReceiptLine `json:"lines"`
+}
+
+// GoodsReceiptLine receives against one order line. Batch-tracked products
+// need a LotNumber and usually an ExpiryDate (YYYY-MM-DD).
+type GoodsReceiptLine struct {
+	LineID     int     `json:"line_id"`
+	ProductID  int     `json:"product_id,omitempty"`
+	Quantity   int     `json:"quantity"`
+	LotNumber  string  `json:"lot_number,omitempty"`
+	ExpiryDate *string `json:"expiry_date,omitempty"`
+}
+
+// GoodsReceiptRequest receives goods against a purchase order. Receiving more
+// than ordered on a line fails unless AllowOverReceipt is set, and Close marks
+// the order received even when some lines are still short.
+type GoodsReceiptRequest struct {
+	Note             string             `json:"note"`
+	AllowOverReceipt bool               `json:"allow_over_receipt"`
+	Close            bool               `json:"close"`
+	Lines            []GoodsReceiptLine `json:"lines"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Stock movement types. Every change to Product.Stock is recorded as one of these.
+const (
+	MovementOpening         = "opening"
+	MovementSale            = "sale"
+	MovementRefund          = "refund"
+	MovementPurchaseReceipt = "purchase_receipt"
+	MovementAdjustment      = "adjustment"
+	MovementTransfer        = "transfer"
+	MovementOpname          = "opname"
+)
+
+// Reason codes accepted for manual stock adjustments.
+const (
+	AdjustmentDamaged = "damaged"
+	AdjustmentLost    = "lost"
+	AdjustmentFound   = "found"
+	AdjustmentExpired = "expired"
+)
+
+// Roles allowed to approve stock adjustments above the configured threshold.
+const (
+	RoleSupervisor = "supervisor"
+	RoleOwner      = "owner"
+)
+
+// StockMovement is one append-only entry of the stock ledger. Quantity is a
+// signed delta in base units and Balance is the product stock right after it.
+type StockMovement struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	Type      string    `json:"type"`
+	Quantity  int       `json:"quantity"`
+	Balance   int       `json:"balance"`
+	Reference string    `json:"reference,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	User      string    `json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StockLedger reconciles a product's stock against the sum of its movements.
+type StockLedger struct {
+	ProductID   int             `json:"product_id"`
+	Stock       int             `json:"stock"`
+	LedgerStock int             `json:"ledger_stock"`
+	Difference  int             `json:"difference"`
+	Movements   []StockMovement `json:"movements"`
+}
+
+// StockAdjustment is a manual correction of stock, in base units.
+type StockAdjustment struct {
+	Delta  int    `json:"delta"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// ProductBatch is a lot of a batch-tracked product. ExpiryDate is formatted
+// YYYY-MM-DD; a batch may be sold up to and including that day.
+type ProductBatch struct {
+	ID          int     `json:"id"`
+	ProductID   int     `json:"product_id"`
+	ProductName string  `json:"product_name,omitempty"`
+	LotNumber   string  `json:"lot_number"`
+	ExpiryDate  *string `json:"expiry_date"`
+	Quantity    int     `json:"quantity"`
+	Expired     bool    `json:"expired"`
+}
+
+// DetailBatch records how much of a sold line came from which batch.
+type DetailBatch struct {
+	BatchID    int     `json:"batch_id"`
+	LotNumber  string  `json:"lot_number"`
+	ExpiryDate *string `json:"expiry_date"`
+	Quantity   int     `json:"quantity"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Stock count (opname) session statuses.
+const (
+	StockCountOpen      = "open"
+	StockCountPosted    = "posted"
+	StockCountCancelled = "cancelled"
+)
+
+// StockCount is a physical stocktake of a category or, when CategoryID is
+// nil, the whole store. Expected stock is snapshotted when it starts.
+type StockCount struct {
+	ID         int              `json:"id"`
+	CategoryID *int             `json:"category_id"`
+	Status     string           `json:"status"`
+	Note       string           `json:"note"`
+	StartedBy  string           `json:"started_by,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	PostedBy   string           `json:"posted_by,omitempty"`
+	PostedAt   *time.Time       `json:"posted_at,omitempty"`
+	Lines      []StockCountLine `json:"lines,omitempty"`
+}
+
+// StockCountLine holds one product of a count. Expected is the stock snapshot
+// taken at the start plus all ledger movements between the start and the
+// moment it was counted, so sales during the count don't show up as variance.
+type StockCountLine struct {
+	ProductID   int        `json:"product_id"`
+	ProductName string     `json:"product_name"`
+	Snapshot    int        `json:"snapshot"`
+	Expected    int        `json:"expected"`
+	Counted     *int       `json:"counted"`
+	Variance    *int       `json:"variance"`
+	CountedBy   string     `json:"counted_by,omitempty"`
+	CountedAt   *time.Time `json:"counted_at,omitempty"`
+}
+
+type StockCountItem struct {
+	ProductID int `json:"product_id"`
+	Counted   int `json:"counted"`
+}
+
+type StockCountSubmission struct {
+	Items []StockCountItem `json:"items"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Transaction struct {
 	ID          int                 `json:"id"`
 	TotalAmount int                 `json:"total_amount"`
@@ -11,26 +13,179 @@ type TransactionDetail struct {
 	TransactionID int    `json:"transaction_id"`
 	ProductID     int    `json:"product_id"`
 	ProductName   string `json:"product_name"`
+	UnitID        *int   `json:"unit_id,omitempty"`
+	UnitName      string `json:"unit_name,omitempty"`
 	Quantity      int    `json:"quantity"`
+	Conversion    int    `json:"conversion"`
+	BaseQuantity  int    `json:"base_quantity"`
 	Subtotal      int    `json:"subtotal"`
+	CostPrice     int    `json:"cost_price"`
+
+	// The product's category when it was sold.
+	CategoryID   *int            `json:"category_id,omitempty"`
+	CategoryName string          `json:"category_name,omitempty"`
+	CategoryPath []CategoryCrumb `json:"category_path,omitempty"`
+
+	Batches []DetailBatch `json:"batches,omitempty"`
 }
 
 type CheckoutRequest struct {
-	Items []CheckoutItem `json:"items"`
+	OutletID *int           `json:"outlet_id,omitempty"`
+	Items    []CheckoutItem `json:"items"`
 }
 
+// CheckoutItem selects what is sold either by product (optionally in one of
+// its alternate units) or by a product or unit barcode.
 type CheckoutItem struct {
-	ProductID int `json:"product_id"`
-	Quantity  int `json:"quantity"`
+	ProductID int    `json:"product_id"`
+	UnitID    *int   `json:"unit_id,omitempty"`
+	Barcode   string `json:"barcode,omitempty"`
+	BatchID   *int   `json:"batch_id,omitempty"`
+	Quantity  int    `json:"quantity"`
 }
 
 type SalesReport struct {
 	TotalRevenue   int        `json:"total_revenue"`
 	TotalTransaksi int        `json:"total_transaksi"`
+	AverageBasket  int        `json:"average_basket"` // revenue per transaction
+	TotalCOGS      int64      `json:"total_cogs"`
+	GrossProfit    int64      `json:"gross_profit"`
+	MarginPercent  float64    `json:"margin_percent"`
 	ProdukTerlaris TopProduct `json:"produk_terlaris"`
+
+	Comparison *SalesComparison `json:"comparison,omitempty"`
+}
+
+// SalesComparison sets a report against an earlier period: the one just
+// before it (Compare "previous"), by calendar month for ranges longer than a
+// week that start on the 1st and by the same number of days otherwise, or
+// the same dates a year earlier ("last_year").
+type SalesComparison struct {
+	Compare       string          `json:"compare"`
+	StartDate     string          `json:"start_date"`
+	EndDate       string          `json:"end_date"`
+	Revenue       Change          `json:"revenue"`
+	Transactions  Change          `json:"transactions"`
+	AverageBasket Change          `json:"average_basket"`
+	TopProducts   []ProductChange `json:"top_products"` // this period's best sellers
+}
+
+// Change is a figure now and in the period compared with. Percent is nil
+// when there is nothing to compare with.
+type Change struct {
+	Current  int      `json:"current"`
+	Previous int      `json:"previous"`
+	Delta    int      `json:"delta"`
+	Percent  *float64 `json:"percent"`
 }
 
+type ProductChange struct {
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name"`
+	QtySold     Change `json:"qty_sold"`
+	Revenue     Change `json:"revenue"`
+}
+
+// TopProduct is the best seller by quantity, kept for older clients; see
+// ProductRanking for the full ranking.
 type TopProduct struct {
 	Nama       string `json:"nama"`
 	QtyTerjual int    `json:"qty_terjual"`
 }
+
+// ProductRanking is one product's place in the sales ranking. Rank counts
+// from the best seller in Top and from the worst in Bottom.
+type ProductRanking struct {
+	Rank         int     `json:"rank"`
+	ProductID    int     `json:"product_id"`
+	ProductName  string  `json:"product_name"`
+	QtySold      int     `json:"qty_sold"`
+	Revenue      int     `json:"revenue"`
+	RevenueShare float64 `json:"revenue_share"` // percent of total revenue
+}
+
+// ProductRankingReport ranks products by quantity or revenue. Active products
+// that sold nothing take part too, so they show up at the bottom.
+type ProductRankingReport struct {
+	By           string           `json:"by"`
+	TotalRevenue int              `json:"total_revenue"`
+	Top          []ProductRanking `json:"top"`
+	Bottom       []ProductRanking `json:"bottom"`
+}
+
+// ProductProfit is one row of the per-product profitability report. Cost is
+// the cost price snapshotted on each sale, not today's cost price.
+type ProductProfit struct {
+	ProductID     int     `json:"product_id"`
+	ProductName   string  `json:"product_name"`
+	QtySold       int     `json:"qty_sold"`
+	Revenue       int     `json:"revenue"`
+	COGS          int64   `json:"cogs"`
+	GrossProfit   int64   `json:"gross_profit"`
+	MarginPercent float64 `json:"margin_percent"`
+}
+
+// SalesPeriod is one day, week or month of the sales time series. Period is
+// its first day; weeks start on Monday.
+type SalesPeriod struct {
+	Period        string  `json:"period"`
+	Revenue       int     `json:"revenue"`
+	Transactions  int     `json:"transactions"`
+	ItemsSold     int     `json:"items_sold"`
+	AverageBasket int     `json:"average_basket"` // revenue per transaction
+	AverageItems  float64 `json:"average_items"`  // items per transaction
+}
+
+// CategorySales is one category's line in the sales by category report.
+// Sales count under the category their product had when sold; a nil
+// CategoryID collects uncategorized sales.
+type CategorySales struct {
+	CategoryID   *int    `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	Revenue      int     `json:"revenue"`
+	Quantity     int     `json:"quantity"`
+	Transactions int     `json:"transactions"`
+	RevenueShare float64 `json:"revenue_share"` // percent of total revenue
+}
+
+// SalesHeatmap buckets sales by day of week and hour of day, read in the
+// store's timezone. Cells has all 7×24 cells, Monday 00:00 first.
+type SalesHeatmap struct {
+	Timezone  string             `json:"timezone"`
+	StartDate string             `json:"start_date"`
+	EndDate   string             `json:"end_date"`
+	Cells     []SalesHeatmapCell `json:"cells"`
+}
+
+type SalesHeatmapCell struct {
+	DayOfWeek    int `json:"day_of_week"` // ISO: 1 is Monday, 7 Sunday
+	Hour         int `json:"hour"`
+	Transactions int `json:"transactions"`
+	Revenue      int `json:"revenue"`
+}
+
+// ReportQuery is the period a report is asked for: either a Period preset
+// (today, yesterday, this_week, last_week, this_month, last_month, ytd) or
+// StartDate and EndDate as ISO dates or datetimes. Empty means today.
+type ReportQuery struct {
+	Period    string
+	StartDate string
+	EndDate   string
+	OutletID  *int
+}
+
+// ReportRange is the time a report covers: the business days StartDate
+// through EndDate (YYYY-MM-DD), which run from From up to but not including
+// To. OutletID narrows it to one outlet's sales.
+type ReportRange struct {
+	StartDate string
+	EndDate   string
+	From      time.Time
+	To        time.Time
+	OutletID  *int
+
+	// Timezone and CutoverHour define the business day, for reports that
+	// group by day or time of day.
+	Timezone    string
+	CutoverHour int
+}
@@ -0,0 +1,96 @@
+// Package notifier delivers stock alerts to the outside world.
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"kasir-api/models"
+)
+
+// Notifier delivers a low-stock alert. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	NotifyLowStock(alert models.LowStockAlert) error
+}
+
+// LogNotifier writes alerts to the standard logger.
+type LogNotifier struct{}
+
+func (LogNotifier) NotifyLowStock(alert models.LowStockAlert) error {
+	log.Printf("⚠️  Low stock: %s (id %d) has %d left, minimum %d, reorder %d",
+		alert.ProductName, alert.ProductID, alert.Stock, alert.MinStock, alert.ReorderQty)
+	return nil
+}
+
+// WebhookNotifier POSTs alerts as JSON to a URL.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) NotifyLowStock(alert models.LowStockAlert) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"event": "low_stock",
+		"alert": alert,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Multi sends every alert to all of its notifiers. When only some of them
+// fail it returns a *PartialError naming those.
+type Multi []Notifier
+
+func (m Multi) NotifyLowStock(alert models.LowStockAlert) error {
+	var failed Multi
+	var errs []error
+	for _, n := range m {
+		if err := n.NotifyLowStock(alert); err != nil {
+			failed = append(failed, n)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(failed) == 0 || len(failed) == len(m) {
+		return errors.Join(errs...)
+	}
+	return &PartialError{Failed: failed, Err: errors.Join(errs...)}
+}
+
+// PartialError means an alert reached some notifiers of a Multi but not the
+// ones in Failed, which are the ones to retry.
+type PartialError struct {
+	Failed Multi
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,208 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"kasir-api/models"
+)
+
+var (
+	ErrExpiredBatch           = errors.New("batch has expired and cannot be sold")
+	ErrInsufficientBatchStock = errors.New("not enough unexpired batch stock")
+)
+
+// Whether a batch has expired depends on the store's business date, which
+// callers pass in as YYYY-MM-DD; the database's own date is in UTC. Queries
+// with batchColumns take it as $1.
+const batchColumns = `b.id, b.product_id, p.name, b.lot_number, TO_CHAR(b.expiry_date, 'YYYY-MM-DD'),
+				b.quantity, COALESCE(b.expiry_date < $1::date, false)`
+
+func scanBatch(row rowScanner) (models.ProductBatch, error) {
+	var b models.ProductBatch
+	err := row.Scan(&b.ID, &b.ProductID, &b.ProductName, &b.LotNumber, &b.ExpiryDate, &b.Quantity, &b.Expired)
+	return b, err
+}
+
+func (repo *StockRepository) queryBatches(query string, args ...interface{}) ([]models.ProductBatch, error) {
+	rows, err := repo.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	batches := make([]models.ProductBatch, 0)
+	for rows.Next() {
+		b, err := scanBatch(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		batches = append(batches, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return batches, nil
+}
+
+func (repo *StockRepository) GetBatches(productID int, today string) ([]models.ProductBatch, error) {
+	query := `SELECT ` + batchColumns + `
+			FROM product_batches b JOIN products p ON p.id = b.product_id
+			WHERE b.product_id = $2 AND b.quantity > 0
+			ORDER BY b.expiry_date NULLS LAST, b.id`
+
+	return repo.queryBatches(query, today, productID)
+}
+
+// GetExpiringBatches lists batches with stock left that expire within the
+// given number of days from today, including ones that have already expired.
+func (repo *StockRepository) GetExpiringBatches(days int, today string) ([]models.ProductBatch, error) {
+	query := `SELECT ` + batchColumns + `
+			FROM product_batches b JOIN products p ON p.id = b.product_id
+			WHERE b.quantity > 0 AND b.expiry_date <= $1::date + $2::int
+			ORDER BY b.expiry_date, p.name, b.id`
+
+	return repo.queryBatches(query, today, days)
+}
+
+// CreateBatch assigns stock the product already has on hand to a new batch,
+// e.g. when batch tracking is switched on for existing stock. The batches
+// may not add up to more than the stock.
+func (repo *StockRepository) CreateBatch(batch *models.ProductBatch, today string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stock, batched int
+	err = tx.QueryRow(`SELECT p.stock, COALESCE((SELECT SUM(quantity) FROM product_batches WHERE product_id = p.id), 0)
+			FROM products p WHERE p.id = $1 FOR UPDATE`, batch.ProductID).Scan(&stock, &batched)
+	if err == sql.ErrNoRows {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("database error %w", err)
+	}
+
+	if batched+batch.Quantity > stock {
+		return fmt.Errorf("%w: %d of %d units are not in a batch yet", ErrInsufficientStock, stock-batched, stock)
+	}
+
+	query := `INSERT INTO product_batches (product_id, lot_number, expiry_date, quantity)
+			VALUES ($1, $2, $3, $4) RETURNING id, COALESCE(expiry_date < $5::date, false)`
+	err = tx.QueryRow(query, batch.ProductID, batch.LotNumber, batch.ExpiryDate, batch.Quantity, today).
+		Scan(&batch.ID, &batch.Expired)
+	if err != nil {
+		return fmt.Errorf("create batch error %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// receiveBatch adds received quantity to the product's batch with lotNumber,
+// creating it if needed, and returns the batch ID.
+func receiveBatch(tx *sql.Tx, productID int, lotNumber string, expiryDate *string, quantity int) (int, error) {
+	query := `INSERT INTO product_batches (product_id, lot_number, expiry_date, quantity)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (product_id, lot_number)
+			DO UPDATE SET quantity = product_batches.quantity + EXCLUDED.quantity,
+				expiry_date = COALESCE(EXCLUDED.expiry_date, product_batches.expiry_date)
+			RETURNING id`
+
+	var id int
+	err := tx.QueryRow(query, productID, lotNumber, expiryDate, quantity).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("receive batch error %w", err)
+	}
+	return id, nil
+}
+
+// sellFromBatches takes quantity out of the product's unexpired batches,
+// earliest expiry first, or out of the one batch given by batchID. It fails
+// rather than sell stock that has expired by today.
+func sellFromBatches(tx *sql.Tx, productID int, batchID *int, quantity int, today string) ([]models.DetailBatch, error) {
+	taken, err := takeFromBatches(tx, productID, batchID, quantity, today)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := quantity
+	for _, b := range taken {
+		remaining -= b.Quantity
+	}
+
+	if remaining > 0 {
+		if batchID != nil {
+			return nil, fmt.Errorf("%w: batch id %d has only %d left", ErrInsufficientBatchStock, *batchID, quantity-remaining)
+		}
+		return nil, fmt.Errorf("%w: product id %d has only %d sellable", ErrInsufficientBatchStock, productID, quantity-remaining)
+	}
+
+	return taken, nil
+}
+
+// releaseFromBatches takes quantity out of the product's batches, earliest
+// expiry (so expired stock) first, for non-sale stock decreases such as
+// write-offs. Whatever the batches cannot cover comes from unbatched stock.
+func releaseFromBatches(tx *sql.Tx, productID, quantity int) error {
+	_, err := takeFromBatches(tx, productID, nil, quantity, "")
+	return err
+}
+
+// takeFromBatches deducts up to quantity from the product's batches in FEFO
+// order and returns what it took, which may be less than asked for. Batches
+// expired by today are skipped; with today empty, expired stock goes too.
+func takeFromBatches(tx *sql.Tx, productID int, batchID *int, quantity int, today string) ([]models.DetailBatch, error) {
+	query := `SELECT id, lot_number, TO_CHAR(expiry_date, 'YYYY-MM-DD'), quantity,
+				COALESCE(expiry_date < NULLIF($3, '')::date, false)
+			FROM product_batches
+			WHERE product_id = $1 AND quantity > 0 AND ($2::int IS NULL OR id = $2)
+			ORDER BY expiry_date NULLS LAST, id
+			FOR UPDATE`
+
+	rows, err := tx.Query(query, productID, batchID, today)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+
+	taken := make([]models.DetailBatch, 0)
+	remaining := quantity
+	for rows.Next() && remaining > 0 {
+		var b models.DetailBatch
+		var available int
+		var expired bool
+		if err := rows.Scan(&b.BatchID, &b.LotNumber, &b.ExpiryDate, &available, &expired); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+
+		if expired {
+			if batchID != nil {
+				rows.Close()
+				return nil, fmt.Errorf("%w: batch id %d expired on %s", ErrExpiredBatch, b.BatchID, *b.ExpiryDate)
+			}
+			continue
+		}
+
+		b.Quantity = min(available, remaining)
+		remaining -= b.Quantity
+		taken = append(taken, b)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	for _, b := range taken {
+		_, err := tx.Exec("UPDATE product_batches SET quantity = quantity - $1 WHERE id = $2", b.Quantity, b.BatchID)
+		if err != nil {
+			return nil, fmt.Errorf("update batch error %w", err)
+		}
+	}
+
+	return taken, nil
+}
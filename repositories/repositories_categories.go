@@ -3,20 +3,52 @@ package repositories
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"kasir-api/models"
 )
 
-type CategoryRepository struct {
-	db *sql.DB
-}
+var (
+	ErrCategoryNotFound = errors.New("kategori tidak ditemukan")
+	ErrInactiveCategory = errors.New("category does not exist or has been deleted")
+	ErrCategoryCycle    = errors.New("a category cannot be moved under itself or its subcategories")
+	ErrCategoryInUse    = errors.New("category still has products")
+)
 
-func NewCategoryRepository(db *sql.DB) *CategoryRepository {
-	return &CategoryRepository{db: db}
+const categoryColumns = "k.id, k.name, k.description, k.parent_id, k.version, k.deleted_at"
+
+// categoryStatsColumns aggregate the products of a category's subtree; they
+// need categoryStatsJoins and the query grouped by k.id. Values are
+// multiplied as bigint, since one product's stock value can overflow int.
+const (
+	categoryStatsColumns = `COUNT(p.id), COALESCE(SUM(GREATEST(p.stock, 0)), 0),
+				COALESCE(SUM(GREATEST(p.stock, 0)::bigint * p.price), 0),
+				COALESCE(SUM(GREATEST(p.stock, 0)::bigint * p.cost_price), 0)`
+	categoryStatsJoins = `
+			LEFT JOIN category_paths cp ON k.id = ANY(cp.path_ids)
+			LEFT JOIN products p ON p.category_id = cp.id AND p.deleted_at IS NULL`
+)
+
+// scanCategory scans categoryColumns followed by any extra selected columns.
+func scanCategory(row rowScanner, extra ...interface{}) (models.Category, error) {
+	var k models.Category
+	dest := []interface{}{&k.ID, &k.Name, &k.Description, &k.ParentID, &k.Version, &k.DeletedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return k, err
 }
 
-func (repo *CategoryRepository) GetAll() ([]models.Category, error) {
-	query := "SELECT id, name, description FROM categories"
-	rows, err := repo.db.Query(query)
+// queryCategories selects the categories matching where, with their stats
+// if withStats.
+func (repo *CategoryRepository) queryCategories(where string, withStats bool, args ...interface{}) ([]models.Category, error) {
+	query := "SELECT " + categoryColumns + " FROM categories k " + where + " ORDER BY k.id"
+	if withStats {
+		query = "SELECT " + categoryColumns + ", " + categoryStatsColumns + `
+			FROM categories k` + categoryStatsJoins + `
+			` + where + `
+			GROUP BY k.id
+			ORDER BY k.id`
+	}
+
+	rows, err := repo.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -25,61 +57,213 @@ func (repo *CategoryRepository) GetAll() ([]models.Category, error) {
 	categories := make([]models.Category, 0)
 	for rows.Next() {
 		var k models.Category
-		err := rows.Scan(&k.ID, &k.Name, &k.Description)
+		if withStats {
+			var stats models.CategoryStats
+			k, err = scanCategory(rows, &stats.ProductCount, &stats.StockUnits, &stats.StockValue, &stats.StockCost)
+			k.Stats = &stats
+		} else {
+			k, err = scanCategory(rows)
+		}
 		if err != nil {
 			return nil, err
 		}
 		categories = append(categories, k)
 	}
 
-	return categories, nil
+	return categories, rows.Err()
+}
+
+type CategoryRepository struct {
+	db *sql.DB
+}
+
+func NewCategoryRepository(db *sql.DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+// GetAll lists active categories, and deleted ones too if includeDeleted.
+func (repo *CategoryRepository) GetAll(includeDeleted, withStats bool) ([]models.Category, error) {
+	return repo.queryCategories("WHERE $1 OR k.deleted_at IS NULL", withStats, includeDeleted)
 }
 
 func (repo *CategoryRepository) Create(category *models.Category) error {
-	query := "INSERT INTO categories (name, description) VALUES ($1, $2) RETURNING id"
-	err := repo.db.QueryRow(query, category.Name, category.Description).Scan(&category.ID)
-	return err
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkParent(tx, 0, category.ParentID); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO categories (name, description, parent_id) VALUES ($1, $2, $3) RETURNING id, version"
+	err = tx.QueryRow(query, category.Name, category.Description, category.ParentID).
+		Scan(&category.ID, &category.Version)
+	if err != nil {
+		return duplicateError(err, map[string]string{"name": category.Name})
+	}
+
+	return tx.Commit()
 }
 
-func (repo *CategoryRepository) GetByID(id int) (*models.Category, error) {
-	query := "SELECT id, name, description FROM categories WHERE id = $1"
+// checkParent makes sure parentID may become the parent of category id: it
+// must be active and be neither the category itself nor below it. Moves are
+// serialised so that two concurrent ones cannot close a loop between them.
+func checkParent(tx *sql.Tx, id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
 
-	var k models.Category
-	err := repo.db.QueryRow(query, id).Scan(&k.ID, &k.Name, &k.Description)
-	if err == sql.ErrNoRows {
-		return nil, errors.New("kategori tidak ditemukan")
+	if err := lockCategoryTree(tx); err != nil {
+		return err
+	}
+
+	if err := activeCategory(tx, parentID); err != nil {
+		return err
 	}
 
+	var cycle bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM category_paths WHERE id = $1 AND $2 = ANY(path_ids))",
+		*parentID, id).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrCategoryCycle
+	}
+	return nil
+}
+
+// lockCategoryTree serialises changes to the shape of the category tree
+// until the transaction ends.
+func lockCategoryTree(tx *sql.Tx) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('kasir-api-category-tree'))")
+	return err
+}
+
+// GetByID returns the category even when it is deleted; callers that hide
+// deleted categories check DeletedAt.
+func (repo *CategoryRepository) GetByID(id int, withStats bool) (*models.Category, error) {
+	categories, err := repo.queryCategories("WHERE k.id = $1", withStats, id)
 	if err != nil {
 		return nil, err
 	}
-	return &k, nil
+	if len(categories) == 0 {
+		return nil, ErrCategoryNotFound
+	}
+	return &categories[0], nil
 }
 
+// Update applies only while the category is still at category.Version
+// (AnyVersion skips the check), and leaves category.Version at the new
+// version.
 func (repo *CategoryRepository) Update(category *models.Category) error {
-	query := "UPDATE categories SET name = $1, description = $2 WHERE id = $3"
-	result, err := repo.db.Exec(query, category.Name, category.Description, category.ID)
+	tx, err := repo.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rows, err := result.RowsAffected()
-	if err != nil {
+	if err := checkParent(tx, category.ID, category.ParentID); err != nil {
 		return err
 	}
 
-	if rows == 0 {
-		return errors.New("kategori tidak ditemukan")
+	query := `UPDATE categories SET name = $1, description = $2, parent_id = $3, version = version + 1
+			WHERE id = $4 AND ` + versionMatches(5) + ` AND deleted_at IS NULL
+			RETURNING version`
+	err = tx.QueryRow(query, category.Name, category.Description, category.ParentID, category.ID, category.Version).
+		Scan(&category.Version)
+	if err == sql.ErrNoRows {
+		return staleOrMissing(tx, "categories", category.ID, ErrCategoryNotFound)
+	}
+	if err != nil {
+		return duplicateError(err, map[string]string{"name": category.Name})
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (repo *CategoryRepository) Delete(id int) error {
-	query := "DELETE FROM categories WHERE id = $1"
-	result, err := repo.db.Exec(query, id)
+// Delete archives a category in one transaction, with its subcategories moved
+// up a level. A category with products is only deleted when they can go
+// somewhere: to reassignTo, or, with force, out of any category. It returns
+// how many active products moved. Like Update, it only applies at the given version.
+func (repo *CategoryRepository) Delete(id int, reassignTo *int, force bool, version int) (int, error) {
+	tx, err := repo.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRow("SELECT version FROM categories WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).
+		Scan(&current)
+	if err == sql.ErrNoRows {
+		return 0, ErrCategoryNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if version != AnyVersion && version != current {
+		return 0, ErrVersionConflict
+	}
+	if err := activeCategory(tx, reassignTo); err != nil {
+		return 0, err
+	}
+
+	if reassignTo == nil && !force {
+		var products int
+		err := tx.QueryRow("SELECT COUNT(*) FROM products WHERE category_id = $1 AND deleted_at IS NULL", id).
+			Scan(&products)
+		if err != nil {
+			return 0, err
+		}
+		if products > 0 {
+			return 0, fmt.Errorf("%w: %d products; pass reassign_to or force=true", ErrCategoryInUse, products)
+		}
+	}
+
+	// Archived products keep the category they were archived in
+	result, err := tx.Exec(`UPDATE products SET category_id = $1, version = version + 1
+			WHERE category_id = $2 AND deleted_at IS NULL`, reassignTo, id)
+	if err != nil {
+		return 0, err
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// Subcategories move up to the deleted category's parent
+	if err := lockCategoryTree(tx); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`UPDATE categories SET version = version + 1,
+				parent_id = (SELECT parent_id FROM categories WHERE id = $1)
+			WHERE parent_id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE categories SET deleted_at = NOW(), version = version + 1 WHERE id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(moved), tx.Commit()
+}
+
+// Restore brings a deleted category back. Its products were moved away when
+// it was deleted and stay where they are.
+func (repo *CategoryRepository) Restore(id int) error {
+	result, err := repo.db.Exec(`UPDATE categories SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		// Another active category may have taken its name meanwhile
+		var name string
+		repo.db.QueryRow("SELECT name FROM categories WHERE id = $1", id).Scan(&name)
+		return duplicateError(err, map[string]string{"name": name})
 	}
 
 	rows, err := result.RowsAffected()
@@ -88,8 +272,16 @@ func (repo *CategoryRepository) Delete(id int) error {
 	}
 
 	if rows == 0 {
-		return errors.New("kategori tidak ditemukan")
+		var exists bool
+		err := repo.db.QueryRow("SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrCategoryNotFound
+		}
+		return ErrNotDeleted
 	}
 
-	return err
+	return nil
 }
@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// DuplicateError reports that a value must be unique and is already taken.
+type DuplicateError struct {
+	Field string
+	Value string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("%s %q is already in use", e.Field, e.Value)
+}
+
+// uniqueFields names the field behind each unique index that users can run
+// into.
+var uniqueFields = map[string]string{
+	"idx_categories_active_name":  "name",
+	"idx_products_active_sku":     "sku",
+	"idx_products_active_barcode": "barcode",
+
+	// product_units
+	"product_units_barcode_key":         "barcode",
+	"product_units_product_id_name_key": "name",
+}
+
+// duplicateError turns a unique violation of one of uniqueFields into a
+// DuplicateError carrying the offending value, and returns any other error
+// as it is.
+func duplicateError(err error, values map[string]string) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+
+	field, ok := uniqueFields[pqErr.Constraint]
+	if !ok {
+		return err
+	}
+	return &DuplicateError{Field: field, Value: values[field]}
+}
@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"kasir-api/models"
+)
+
+var ErrOutletNotFound = errors.New("outlet not found")
+
+type OutletRepository struct {
+	db *sql.DB
+}
+
+func NewOutletRepository(db *sql.DB) *OutletRepository {
+	return &OutletRepository{db: db}
+}
+
+func (repo *OutletRepository) GetAll() ([]models.Outlet, error) {
+	query := "SELECT id, name, timezone, day_cutover_hour FROM outlets ORDER BY name, id"
+	rows, err := repo.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	outlets := make([]models.Outlet, 0)
+	for rows.Next() {
+		var o models.Outlet
+		err := rows.Scan(&o.ID, &o.Name, &o.Timezone, &o.DayCutoverHour)
+		if err != nil {
+			return nil, err
+		}
+		outlets = append(outlets, o)
+	}
+
+	return outlets, rows.Err()
+}
+
+func (repo *OutletRepository) GetByID(id int) (*models.Outlet, error) {
+	query := "SELECT id, name, timezone, day_cutover_hour FROM outlets WHERE id = $1"
+
+	var o models.Outlet
+	err := repo.db.QueryRow(query, id).Scan(&o.ID, &o.Name, &o.Timezone, &o.DayCutoverHour)
+	if err == sql.ErrNoRows {
+		return nil, ErrOutletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (repo *OutletRepository) Create(outlet *models.Outlet) error {
+	query := "INSERT INTO outlets (name, timezone, day_cutover_hour) VALUES ($1, $2, $3) RETURNING id"
+	return repo.db.QueryRow(query, outlet.Name, outlet.Timezone, outlet.DayCutoverHour).Scan(&outlet.ID)
+}
+
+func (repo *OutletRepository) Update(outlet *models.Outlet) error {
+	query := "UPDATE outlets SET name = $1, timezone = $2, day_cutover_hour = $3 WHERE id = $4"
+	result, err := repo.db.Exec(query, outlet.Name, outlet.Timezone, outlet.DayCutoverHour, outlet.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrOutletNotFound
+	}
+
+	return nil
+}
@@ -2,11 +2,53 @@ package repositories
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"kasir-api/models"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrProductNotFound = errors.New("produk tidak ditemukan")
+	ErrNotDeleted      = errors.New("not deleted, nothing to restore")
 )
 
+// ErrVersionConflict means a conditional write was made against a version
+// that is no longer current.
+var ErrVersionConflict = errors.New("version does not match; reload and try again")
+
+// AnyVersion makes a conditional write unconditional, for If-Match: *.
+// Versions start at 1, so 0 matches no row.
+const AnyVersion = -1
+
+// versionMatches is the SQL condition that the row is at the version bound
+// to parameter $n, or that $n is AnyVersion.
+func versionMatches(n int) string {
+	return fmt.Sprintf("($%d = %d OR version = $%d)", n, AnyVersion, n)
+}
+
+// staleOrMissing explains why a write on table conditional on the row's
+// version touched nothing: either the row is gone (or deleted) or its version
+// moved on.
+func staleOrMissing(q queryer, table string, id int, notFound error) error {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = $1 AND deleted_at IS NULL)", id).
+		Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("database error %w", err)
+	}
+	if !exists {
+		return notFound
+	}
+	return ErrVersionConflict
+}
+
 type ProductRepository struct {
 	db *sql.DB
 }
@@ -15,21 +57,222 @@ func NewProductRepository(db *sql.DB) *ProductRepository {
 	return &ProductRepository{db: db}
 }
 
-func (repo *ProductRepository) GetAll(name string) ([]models.Product, error) {
-	query := `SELECT 
-				p.id, p.name, p.price, p.stock, p.category_id, 
-				c.id as cat_id, c.name as cat_name, c.description as cat_description
-			FROM products p INNER JOIN
-			categories c ON p.category_id = c.id `
+// productColumns and scanProduct are shared by every query that returns
+// products with their category; they need categories c and category_paths cp
+// joined.
+const productColumns = `
+				p.id, p.name, COALESCE(p.sku, ''), COALESCE(p.barcode, ''), COALESCE(p.brand, ''), p.price, p.cost_price, p.stock, p.min_stock, p.reorder_qty, p.track_batches, p.category_id, p.version, p.deleted_at,
+				c.id as cat_id, c.name as cat_name, c.description as cat_description, c.parent_id as cat_parent_id,
+				cp.path_ids, cp.path_names`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProduct scans productColumns followed by any extra selected columns.
+func scanProduct(row rowScanner, extra ...interface{}) (models.Product, error) {
+	var p models.Product
+	var catID sql.NullInt64
+	var catName sql.NullString
+	var catDesc sql.NullString
+	var catParentID *int
+	var pathIDs pq.Int64Array
+	var pathNames pq.StringArray
+
+	dest := []interface{}{
+		&p.ID, &p.Name, &p.SKU, &p.Barcode, &p.Brand, &p.Price, &p.CostPrice, &p.Stock, &p.MinStock,
+		&p.ReorderQty, &p.TrackBatches, &p.CategoryID, &p.Version, &p.DeletedAt,
+		&catID, &catName, &catDesc, &catParentID,
+		&pathIDs, &pathNames,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return p, err
+	}
+
+	if catID.Valid {
+		categoryID := int(catID.Int64)
+		p.CategoryID = &categoryID
+		p.Category = &models.Category{
+			ID:          int(catID.Int64),
+			Name:        catName.String,
+			Description: catDesc.String,
+			ParentID:    catParentID,
+		}
+	}
+
+	for i := range pathIDs {
+		p.CategoryPath = append(p.CategoryPath, models.CategoryCrumb{ID: int(pathIDs[i]), Name: pathNames[i]})
+	}
+
+	return p, nil
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// productSortColumns maps the sort keys accepted by GetAll to columns. Every
+// sort is made unique by p.id, which keyset pagination relies on.
+var productSortColumns = map[string]string{
+	"id":    "p.id",
+	"name":  "p.name",
+	"price": "p.price",
+	"stock": "p.stock",
+}
+
+// productCursor is the position after the last row of a page. It records
+// the sort it was made for so it can't be replayed against another one.
+type productCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeProductCursor(c productCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProductCursor(s string) (productCursor, error) {
+	var c productCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// GetAll returns one page of products using keyset pagination, so deep pages
+// cost the same as the first one.
+func (repo *ProductRepository) GetAll(filter models.ProductFilter) (*models.ProductPage, error) {
+	sortColumn, ok := productSortColumns[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort %q", filter.Sort)
+	}
 
+	conditions := []string{}
 	args := []interface{}{}
-	if name != "" {
-		query += " WHERE p.name ILIKE $1"
-		args = append(args, "%"+name+"%")
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "p.deleted_at IS NULL")
+	}
+	if filter.Name != "" {
+		addCondition("p.name ILIKE $%d", "%"+filter.Name+"%")
+	}
+	if filter.Uncategorized {
+		conditions = append(conditions, "p.category_id IS NULL")
+	} else if filter.CategoryID != nil {
+		// A category includes everything in its subcategories
+		addCondition("p.category_id IN (SELECT id FROM category_paths WHERE $%d = ANY(path_ids))", *filter.CategoryID)
+	}
+	if filter.MinPrice != nil {
+		addCondition("p.price >= $%d", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		addCondition("p.price <= $%d", *filter.MaxPrice)
+	}
+	if filter.MinStock != nil {
+		addCondition("p.stock >= $%d", *filter.MinStock)
+	}
+	if filter.MaxStock != nil {
+		addCondition("p.stock <= $%d", *filter.MaxStock)
+	}
+	if filter.InStock {
+		conditions = append(conditions, "p.stock > 0")
+	}
+
+	from := `
+			FROM products p LEFT JOIN
+			categories c ON p.category_id = c.id LEFT JOIN
+			category_paths cp ON cp.id = p.category_id `
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page := &models.ProductPage{}
+	err := repo.db.QueryRow("SELECT COUNT(*)"+from+where, args...).Scan(&page.TotalCount)
+	if err != nil {
+		return nil, fmt.Errorf("count error: %w", err)
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeProductCursor(filter.Cursor)
+		if err != nil || cursor.Sort != filter.Sort || cursor.Desc != filter.Desc {
+			return nil, ErrInvalidCursor
+		}
+
+		op := ">"
+		if filter.Desc {
+			op = "<"
+		}
+
+		if filter.Sort == "id" {
+			addCondition("p.id "+op+" $%d", cursor.ID)
+		} else {
+			var value interface{} = cursor.Value
+			if filter.Sort != "name" {
+				n, err := strconv.Atoi(cursor.Value)
+				if err != nil {
+					return nil, ErrInvalidCursor
+				}
+				value = n
+			}
+			args = append(args, value, cursor.ID)
+			conditions = append(conditions,
+				fmt.Sprintf("(%s, p.id) %s ($%d, $%d)", sortColumn, op, len(args)-1, len(args)))
+		}
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+
+	query := `SELECT ` + productColumns + from + where
+	if filter.Sort == "id" {
+		query += " ORDER BY p.id " + direction
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, p.id %s", sortColumn, direction, direction)
 	}
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
 
-	query += " ORDER BY p.id"
+	products, err := repo.queryProducts(query, args...)
+	if err != nil {
+		return nil, err
+	}
 
+	if len(products) > filter.Limit {
+		products = products[:filter.Limit]
+		last := products[len(products)-1]
+
+		cursor := productCursor{Sort: filter.Sort, Desc: filter.Desc, ID: last.ID}
+		switch filter.Sort {
+		case "name":
+			cursor.Value = last.Name
+		case "price":
+			cursor.Value = strconv.Itoa(last.Price)
+		case "stock":
+			cursor.Value = strconv.Itoa(last.Stock)
+		}
+		page.NextCursor = encodeProductCursor(cursor)
+	}
+
+	page.Items = products
+	return page, nil
+}
+
+func (repo *ProductRepository) queryProducts(query string, args ...interface{}) ([]models.Product, error) {
 	rows, err := repo.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
@@ -38,33 +281,12 @@ func (repo *ProductRepository) GetAll(name string) ([]models.Product, error) {
 
 	products := make([]models.Product, 0)
 	for rows.Next() {
-		var p models.Product
-		var catID sql.NullInt64
-		var catName sql.NullString
-		var catDesc sql.NullString
-
-		err := rows.Scan(
-			&p.ID, &p.Name, &p.Price, &p.Stock, &p.CategoryID,
-			&catID, &catName, &catDesc,
-		)
+		p, err := scanProduct(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan error %w", err)
 		}
 
-		if catID.Valid {
-			categoryID := int(catID.Int64)
-			p.CategoryID = &categoryID
-			p.Category = &models.Category{
-				ID:          int(catID.Int64),
-				Name:        catName.String,
-				Description: catDesc.String,
-			}
-		} else {
-			continue
-		}
-
 		products = append(products, p)
-
 	}
 
 	if err = rows.Err(); err != nil {
@@ -74,70 +296,220 @@ func (repo *ProductRepository) GetAll(name string) ([]models.Product, error) {
 	return products, nil
 }
 
-func (repo *ProductRepository) Create(product *models.Product) error {
-	if product.CategoryID == nil {
-		return errors.New("category_id is required")
+func (repo *ProductRepository) Create(product *models.Product, user string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := activeCategory(tx, product.CategoryID); err != nil {
+		return err
+	}
+	if err := claimBarcode(tx, product.Barcode, unitBarcodeTaken); err != nil {
+		return err
 	}
 
-	query := "INSERT INTO products (name, price, stock, category_id) VALUES ($1, $2, $3, $4) RETURNING id"
-	err := repo.db.QueryRow(query, product.Name, product.Price, product.Stock, *product.CategoryID).Scan(&product.ID)
+	query := `INSERT INTO products (name, sku, barcode, brand, price, cost_price, stock, min_stock, reorder_qty,
+				track_batches, category_id)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, $6, 0, $7, $8, $9, $10) RETURNING id`
+	err = tx.QueryRow(query, product.Name, product.SKU, product.Barcode, product.Brand, product.Price,
+		product.CostPrice, product.MinStock, product.ReorderQty, product.TrackBatches, product.CategoryID).
+		Scan(&product.ID)
+
+	if err != nil {
+		return fmt.Errorf("create error %w", duplicateError(err, productUniqueValues(product)))
+	}
 
+	err = applyStockMovement(tx, &models.StockMovement{
+		ProductID: product.ID,
+		Type:      models.MovementOpening,
+		Quantity:  product.Stock,
+		User:      user,
+	})
 	if err != nil {
-		return fmt.Errorf("create error %w", err)
+		return err
 	}
 
+	return tx.Commit()
+
+}
+
+func productUniqueValues(p *models.Product) map[string]string {
+	return map[string]string{"sku": p.SKU, "barcode": p.Barcode}
+}
+
+// Checkout looks a barcode up among products and selling units alike, but
+// each table's unique index only covers itself.
+const (
+	productBarcodeTaken = `SELECT EXISTS (SELECT 1 FROM products WHERE barcode = $1 AND deleted_at IS NULL)`
+	unitBarcodeTaken    = `SELECT EXISTS (SELECT 1 FROM product_units u JOIN products p ON p.id = u.product_id
+				WHERE u.barcode = $1 AND p.deleted_at IS NULL)`
+)
+
+// claimBarcode locks barcode until tx ends and refuses it if takenQuery, run
+// against the other table, finds it already in use there.
+func claimBarcode(tx *sql.Tx, barcode, takenQuery string) error {
+	if barcode == "" {
+		return nil
+	}
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('barcode:' || $1))", barcode); err != nil {
+		return fmt.Errorf("database error %w", err)
+	}
+
+	var taken bool
+	if err := tx.QueryRow(takenQuery, barcode).Scan(&taken); err != nil {
+		return fmt.Errorf("database error %w", err)
+	}
+	if taken {
+		return &DuplicateError{Field: "barcode", Value: barcode}
+	}
 	return nil
+}
 
+// activeCategory checks that a product's category, if it has one, exists and
+// is not deleted.
+func activeCategory(q queryer, categoryID *int) error {
+	if categoryID == nil {
+		return nil
+	}
+
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1 AND deleted_at IS NULL)", *categoryID).
+		Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("database error %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: id %d", ErrInactiveCategory, *categoryID)
+	}
+	return nil
 }
 
+// GetByID returns the product even when it is archived; callers that hide
+// archived products check DeletedAt.
 func (repo *ProductRepository) GetByID(id int) (*models.Product, error) {
-	query := `SELECT
-				p.id, p.name, p.price, p.stock, p.category_id,
-				c.id as cat_id, c.name as cat_name, c.description as cat_description
-			FROM products p INNER JOIN
-			categories c ON p.category_id = c.id
+	query := `SELECT ` + productColumns + `
+			FROM products p LEFT JOIN
+			categories c ON p.category_id = c.id LEFT JOIN
+			category_paths cp ON cp.id = p.category_id
 			WHERE p.id = $1 `
 
-	var p models.Product
-	var catID sql.NullInt64
-	var catName sql.NullString
-	var catDesc sql.NullString
-	err := repo.db.QueryRow(query, id).Scan(&p.ID, &p.Name, &p.Price, &p.Stock, &p.CategoryID,
-		&catID, &catName, &catDesc,
-	)
+	p, err := scanProduct(repo.db.QueryRow(query, id))
 	if err == sql.ErrNoRows {
-		return nil, errors.New("produk tidak ditemukan atau tidak memiliki kategori")
+		return nil, ErrProductNotFound
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("database error %w", err)
 	}
 
-	if !catID.Valid {
-		return nil, errors.New("Kategori tidak valid")
-	}
-
-	categoryID := int(catID.Int64)
-	p.CategoryID = &categoryID
-	p.Category = &models.Category{
-		ID:          int(catID.Int64),
-		Name:        catName.String,
-		Description: catDesc.String,
+	p.Units, err = repo.GetUnits(p.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	return &p, nil
 
 }
 
+// Update edits the product's details. Stock is deliberately left alone; it
+// only changes through the stock ledger. The update only applies while the
+// product is still at product.Version (AnyVersion skips the check), and leaves
+// product.Version at the new version.
 func (repo *ProductRepository) Update(product *models.Product) error {
-	if product.CategoryID == nil {
-		return errors.New("category_id is required")
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := activeCategory(tx, product.CategoryID); err != nil {
+		return err
+	}
+	if err := claimBarcode(tx, product.Barcode, unitBarcodeTaken); err != nil {
+		return err
 	}
 
-	query := "UPDATE products SET name = $1, price = $2, stock = $3, category_id = $4 WHERE id = $5"
-	result, err := repo.db.Exec(query, product.Name, product.Price, product.Stock, *product.CategoryID, product.ID)
+	query := `UPDATE products SET name = $1, sku = NULLIF($2, ''), barcode = NULLIF($3, ''), brand = NULLIF($4, ''),
+				price = $5, min_stock = $6, reorder_qty = $7, track_batches = $8, category_id = $9,
+				version = version + 1
+			WHERE id = $10 AND ` + versionMatches(11) + ` AND deleted_at IS NULL
+			RETURNING version`
+	err = tx.QueryRow(query, product.Name, product.SKU, product.Barcode, product.Brand, product.Price,
+		product.MinStock, product.ReorderQty, product.TrackBatches, product.CategoryID, product.ID, product.Version).
+		Scan(&product.Version)
+	if err == sql.ErrNoRows {
+		return staleOrMissing(tx, "products", product.ID, ErrProductNotFound)
+	}
 	if err != nil {
-		return fmt.Errorf("update error %w", err)
+		return fmt.Errorf("update error %w", duplicateError(err, productUniqueValues(product)))
+	}
+
+	return tx.Commit()
+}
+
+// Delete archives the product if it is still at version (AnyVersion skips the
+// check).
+// The row stays so that past transactions, movements and purchase orders
+// still point at it.
+func (repo *ProductRepository) Delete(id, version int) error {
+	query := `UPDATE products SET deleted_at = NOW(), version = version + 1
+			WHERE id = $1 AND ` + versionMatches(2) + ` AND deleted_at IS NULL`
+	result, err := repo.db.Exec(query, id, version)
+	if err != nil {
+		return fmt.Errorf("delete error %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected error: %w", err)
+	}
+
+	if rows == 0 {
+		return staleOrMissing(repo.db, "products", id, ErrProductNotFound)
+	}
+
+	return err
+}
+
+// Restore brings an archived product back into the catalogue, leaving its
+// category if that has been deleted since.
+func (repo *ProductRepository) Restore(id int) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Another active product, or one of its units, may have taken its SKU or
+	// barcodes meanwhile
+	var product models.Product
+	err = tx.QueryRow("SELECT COALESCE(sku, ''), COALESCE(barcode, '') FROM products WHERE id = $1", id).
+		Scan(&product.SKU, &product.Barcode)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("database error %w", err)
+	}
+	if err := claimBarcode(tx, product.Barcode, unitBarcodeTaken); err != nil {
+		return err
+	}
+	units, err := unitsOf(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, u := range units {
+		if err := claimBarcode(tx, u.Barcode, productBarcodeTaken); err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE products SET deleted_at = NULL, version = version + 1,
+				category_id = (SELECT k.id FROM categories k WHERE k.id = products.category_id AND k.deleted_at IS NULL)
+			WHERE id = $1 AND deleted_at IS NOT NULL`
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("restore error %w", duplicateError(err, productUniqueValues(&product)))
 	}
 
 	rows, err := result.RowsAffected()
@@ -146,17 +518,267 @@ func (repo *ProductRepository) Update(product *models.Product) error {
 	}
 
 	if rows == 0 {
-		return errors.New("produk tidak ditemukan")
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", id).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("database error %w", err)
+		}
+		if !exists {
+			return ErrProductNotFound
+		}
+		return ErrNotDeleted
+	}
+
+	return tx.Commit()
+}
+
+// Search ranks products against a cashier's free-text query. Full-text
+// matches on prefixes of every word rank highest, trigram similarity on the
+// name, brand and category name catches typos, and an exact SKU or barcode
+// always wins. Candidates are collected branch by branch so that each one
+// can use its own index, and only those get ranked.
+func (repo *ProductRepository) Search(q string, limit int) ([]models.ProductSearchResult, error) {
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return []models.ProductSearchResult{}, nil
+	}
+
+	// Terms contain only letters and digits, so they are safe in tsquery syntax
+	allTerms := strings.Join(terms, ":* & ") + ":*"
+	anyTerm := strings.Join(terms, ":* | ") + ":*"
+
+	query := `WITH candidates AS (
+				SELECT id FROM products WHERE search_vector @@ to_tsquery('simple', $2)
+				UNION SELECT id FROM products WHERE $1 <% name
+				UNION SELECT id FROM products WHERE $1 <% brand
+				UNION SELECT id FROM products WHERE sku = $1 AND deleted_at IS NULL
+				UNION SELECT id FROM products WHERE barcode = $1 AND deleted_at IS NULL
+				UNION SELECT id FROM products WHERE category_id IN (SELECT id FROM categories WHERE name % $1)
+			)
+			SELECT ` + productColumns + `,
+				ts_headline('simple', p.name, to_tsquery('simple', $3),
+					'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+				ts_headline('simple', COALESCE(p.brand, ''), to_tsquery('simple', $3),
+					'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+				ts_rank(p.search_vector, to_tsquery('simple', $2)) * 2
+					+ word_similarity($1, p.name)
+					+ word_similarity($1, COALESCE(p.brand, '')) * 0.5
+					+ COALESCE(similarity($1, c.name), 0) * 0.5
+					+ CASE WHEN p.sku = $1 OR p.barcode = $1 THEN 10 ELSE 0 END AS score
+			FROM candidates m
+			JOIN products p ON p.id = m.id LEFT JOIN
+			categories c ON p.category_id = c.id LEFT JOIN
+			category_paths cp ON cp.id = p.category_id
+			WHERE p.deleted_at IS NULL
+			ORDER BY score DESC, p.id
+			LIMIT $4`
+
+	rows, err := repo.db.Query(query, strings.TrimSpace(q), allTerms, anyTerm, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]models.ProductSearchResult, 0)
+	for rows.Next() {
+		var r models.ProductSearchResult
+		var nameHighlight, brandHighlight string
+
+		r.Product, err = scanProduct(rows, &nameHighlight, &brandHighlight, &r.Score)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+
+		r.Highlights = map[string]string{"name": nameHighlight}
+		if r.Brand != "" {
+			r.Highlights["brand"] = brandHighlight
+		}
+		results = append(results, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return results, nil
+}
+
+// searchTerms splits a query into lowercase words of letters and digits.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// GetLowStock lists products at or below their minimum stock, emptiest first.
+func (repo *ProductRepository) GetLowStock() ([]models.Product, error) {
+	query := `SELECT ` + productColumns + `
+			FROM products p LEFT JOIN
+			categories c ON p.category_id = c.id LEFT JOIN
+			category_paths cp ON cp.id = p.category_id
+			WHERE p.min_stock > 0 AND p.stock <= p.min_stock AND p.deleted_at IS NULL
+			ORDER BY p.stock - p.min_stock, p.id`
+
+	rows, err := repo.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	products := make([]models.Product, 0)
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		products = append(products, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return products, nil
+}
+
+// ClaimLowStockAlerts marks the given products (all products when ids is
+// nil) that are at or below their minimum and not yet alerted, and returns
+// them. Claiming is atomic so each dip below the threshold alerts once, even
+// with several API instances running.
+func (repo *ProductRepository) ClaimLowStockAlerts(ids []int) ([]models.LowStockAlert, error) {
+	query := `UPDATE products SET low_stock_alerted_at = NOW()
+			WHERE ($1::int[] IS NULL OR id = ANY($1))
+				AND min_stock > 0 AND stock <= min_stock
+				AND low_stock_alerted_at IS NULL AND deleted_at IS NULL
+			RETURNING id, name, stock, min_stock, reorder_qty, low_stock_alerted_at`
+
+	var arg interface{}
+	if ids != nil {
+		arg = pq.Array(ids)
+	}
+
+	rows, err := repo.db.Query(query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
 	}
+	defer rows.Close()
+
+	alerts := make([]models.LowStockAlert, 0)
+	for rows.Next() {
+		var a models.LowStockAlert
+		err := rows.Scan(&a.ProductID, &a.ProductName, &a.Stock, &a.MinStock, &a.ReorderQty, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return alerts, nil
+}
 
+// ReleaseLowStockAlert un-marks a product so that it is alerted again, used
+// when delivering the alert failed.
+func (repo *ProductRepository) ReleaseLowStockAlert(productID int) error {
+	_, err := repo.db.Exec("UPDATE products SET low_stock_alerted_at = NULL WHERE id = $1", productID)
+	if err != nil {
+		return fmt.Errorf("update error %w", err)
+	}
 	return nil
 }
 
-func (repo *ProductRepository) Delete(id int) error {
-	query := "DELETE FROM products WHERE id = $1"
-	result, err := repo.db.Exec(query, id)
+// ResetRecoveredLowStock clears the alert mark of products whose stock has
+// recovered above their minimum, re-arming the alert for the next dip, and
+// returns their ids.
+func (repo *ProductRepository) ResetRecoveredLowStock() ([]int, error) {
+	rows, err := repo.db.Query(`UPDATE products SET low_stock_alerted_at = NULL
+			WHERE low_stock_alerted_at IS NOT NULL AND stock > min_stock
+			RETURNING id`)
 	if err != nil {
-		return fmt.Errorf("delete error %w", err)
+		return nil, fmt.Errorf("update error %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return ids, nil
+}
+
+func (repo *ProductRepository) GetUnits(productID int) ([]models.ProductUnit, error) {
+	return unitsOf(repo.db, productID)
+}
+
+func unitsOf(q queryer, productID int) ([]models.ProductUnit, error) {
+	query := `SELECT id, product_id, name, conversion, price, COALESCE(barcode, '')
+			FROM product_units WHERE product_id = $1 ORDER BY conversion, id`
+
+	rows, err := q.Query(query, productID)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	units := make([]models.ProductUnit, 0)
+	for rows.Next() {
+		var u models.ProductUnit
+		if err := rows.Scan(&u.ID, &u.ProductID, &u.Name, &u.Conversion, &u.Price, &u.Barcode); err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		units = append(units, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return units, nil
+}
+
+// CreateUnit adds a selling unit. Units are part of the product, so its
+// version goes up with them.
+func (repo *ProductRepository) CreateUnit(unit *models.ProductUnit) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := claimBarcode(tx, unit.Barcode, productBarcodeTaken); err != nil {
+		return err
+	}
+
+	query := `WITH bumped AS (UPDATE products SET version = version + 1 WHERE id = $1)
+			INSERT INTO product_units (product_id, name, conversion, price, barcode)
+			VALUES ($1, $2, $3, $4, NULLIF($5, '')) RETURNING id`
+	err = tx.QueryRow(query, unit.ProductID, unit.Name, unit.Conversion, unit.Price, unit.Barcode).Scan(&unit.ID)
+	if err != nil {
+		return fmt.Errorf("create unit error %w",
+			duplicateError(err, map[string]string{"name": unit.Name, "barcode": unit.Barcode}))
+	}
+
+	return tx.Commit()
+}
+
+func (repo *ProductRepository) DeleteUnit(productID, unitID int) error {
+	query := `WITH deleted AS (DELETE FROM product_units WHERE id = $1 AND product_id = $2 RETURNING product_id)
+			UPDATE products SET version = version + 1 WHERE id IN (SELECT product_id FROM deleted)`
+	result, err := repo.db.Exec(query, unitID, productID)
+	if err != nil {
+		return fmt.Errorf("delete unit error %w", err)
 	}
 
 	rows, err := result.RowsAffected()
@@ -165,8 +787,8 @@ func (repo *ProductRepository) Delete(id int) error {
 	}
 
 	if rows == 0 {
-		return errors.New("produk tidak ditemukan")
+		return errors.New("satuan tidak ditemukan")
 	}
 
-	return err
+	return nil
 }
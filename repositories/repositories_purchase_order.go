@@ -0,0 +1,389 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"kasir-api/models"
+)
+
+var (
+	ErrPurchaseOrderStatus = errors.New("purchase order status does not allow this action")
+	ErrOverReceipt         = errors.New("received quantity exceeds the ordered quantity")
+	ErrArchivedProduct     = errors.New("product is archived and cannot be ordered or received")
+)
+
+type PurchaseOrderRepository struct {
+	db *sql.DB
+}
+
+func NewPurchaseOrderRepository(db *sql.DB) *PurchaseOrderRepository {
+	return &PurchaseOrderRepository{db: db}
+}
+
+func (repo *PurchaseOrderRepository) GetAll(status string) ([]models.PurchaseOrder, error) {
+	query := `SELECT po.id, po.supplier_id, po.status, po.note, COALESCE(po.created_by, ''),
+				po.created_at, po.updated_at, s.id, s.name, s.phone, s.email, s.address
+			FROM purchase_orders po JOIN suppliers s ON s.id = po.supplier_id`
+
+	args := []interface{}{}
+	if status != "" {
+		query += " WHERE po.status = $1"
+		args = append(args, status)
+	}
+
+	query += " ORDER BY po.id DESC"
+
+	rows, err := repo.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make([]models.PurchaseOrder, 0)
+	for rows.Next() {
+		po, err := scanPurchaseOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		orders = append(orders, po)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return orders, nil
+}
+
+func scanPurchaseOrder(row rowScanner) (models.PurchaseOrder, error) {
+	var po models.PurchaseOrder
+	var s models.Supplier
+	err := row.Scan(&po.ID, &po.SupplierID, &po.Status, &po.Note, &po.CreatedBy,
+		&po.CreatedAt, &po.UpdatedAt, &s.ID, &s.Name, &s.Phone, &s.Email, &s.Address)
+	po.Supplier = &s
+	return po, err
+}
+
+func (repo *PurchaseOrderRepository) Create(po *models.PurchaseOrder) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO purchase_orders (supplier_id, note, created_by)
+			VALUES ($1, $2, NULLIF($3, '')) RETURNING id`
+	err = tx.QueryRow(query, po.SupplierID, po.Note, po.CreatedBy).Scan(&po.ID)
+	if err != nil {
+		return fmt.Errorf("create error %w", err)
+	}
+
+	if err := insertPurchaseOrderLines(tx, po.ID, po.Lines); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertPurchaseOrderLines adds lines for existing, active products. Each
+// product stays locked against archiving until tx ends.
+func insertPurchaseOrderLines(tx *sql.Tx, poID int, lines []models.PurchaseOrderLine) error {
+	for i := range lines {
+		var archived bool
+		err := tx.QueryRow("SELECT deleted_at IS NOT NULL FROM products WHERE id = $1 FOR SHARE", lines[i].ProductID).
+			Scan(&archived)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: id %d", ErrProductNotFound, lines[i].ProductID)
+		}
+		if err != nil {
+			return fmt.Errorf("database error %w", err)
+		}
+		if archived {
+			return fmt.Errorf("%w: product id %d", ErrArchivedProduct, lines[i].ProductID)
+		}
+
+		err = tx.QueryRow(`INSERT INTO purchase_order_lines (purchase_order_id, product_id, quantity, cost_price)
+				VALUES ($1, $2, $3, $4) RETURNING id`,
+			poID, lines[i].ProductID, lines[i].Quantity, lines[i].CostPrice).Scan(&lines[i].ID)
+		if err != nil {
+			return fmt.Errorf("create line error %w", err)
+		}
+	}
+	return nil
+}
+
+func (repo *PurchaseOrderRepository) GetByID(id int) (*models.PurchaseOrder, error) {
+	query := `SELECT po.id, po.supplier_id, po.status, po.note, COALESCE(po.created_by, ''),
+				po.created_at, po.updated_at, s.id, s.name, s.phone, s.email, s.address
+			FROM purchase_orders po JOIN suppliers s ON s.id = po.supplier_id
+			WHERE po.id = $1`
+
+	po, err := scanPurchaseOrder(repo.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("purchase order tidak ditemukan")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error %w", err)
+	}
+
+	po.Lines, err = repo.getLines(id)
+	if err != nil {
+		return nil, err
+	}
+
+	po.Receipts, err = repo.getReceipts(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &po, nil
+}
+
+func (repo *PurchaseOrderRepository) getLines(poID int) ([]models.PurchaseOrderLine, error) {
+	query := `SELECT l.id, l.product_id, p.name, l.quantity, l.received_qty, l.cost_price
+			FROM purchase_order_lines l JOIN products p ON p.id = l.product_id
+			WHERE l.purchase_order_id = $1 ORDER BY l.id`
+
+	rows, err := repo.db.Query(query, poID)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	lines := make([]models.PurchaseOrderLine, 0)
+	for rows.Next() {
+		var l models.PurchaseOrderLine
+		err := rows.Scan(&l.ID, &l.ProductID, &l.ProductName, &l.Quantity, &l.ReceivedQty, &l.CostPrice)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		lines = append(lines, l)
+	}
+
+	return lines, rows.Err()
+}
+
+func (repo *PurchaseOrderRepository) getReceipts(poID int) ([]models.GoodsReceipt, error) {
+	query := `SELECT r.id, r.note, COALESCE(r.received_by, ''), r.received_at,
+				rl.purchase_order_line_id, pl.product_id, rl.quantity,
+				COALESCE(b.lot_number, ''), TO_CHAR(b.expiry_date, 'YYYY-MM-DD')
+			FROM goods_receipts r
+			JOIN goods_receipt_lines rl ON rl.goods_receipt_id = r.id
+			JOIN purchase_order_lines pl ON pl.id = rl.purchase_order_line_id
+			LEFT JOIN product_batches b ON b.id = rl.batch_id
+			WHERE r.purchase_order_id = $1
+			ORDER BY r.id, rl.id`
+
+	rows, err := repo.db.Query(query, poID)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := make([]models.GoodsReceipt, 0)
+	for rows.Next() {
+		var r models.GoodsReceipt
+		var l models.GoodsReceiptLine
+		err := rows.Scan(&r.ID, &r.Note, &r.ReceivedBy, &r.ReceivedAt, &l.LineID, &l.ProductID, &l.Quantity,
+			&l.LotNumber, &l.ExpiryDate)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+
+		if n := len(receipts); n == 0 || receipts[n-1].ID != r.ID {
+			receipts = append(receipts, r)
+		}
+		last := &receipts[len(receipts)-1]
+		last.Lines = append(last.Lines, l)
+	}
+
+	return receipts, rows.Err()
+}
+
+// UpdateDraft replaces the supplier, note and lines of a draft order.
+func (repo *PurchaseOrderRepository) UpdateDraft(po *models.PurchaseOrder) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPurchaseOrder(tx, po.ID, models.POStatusDraft); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE purchase_orders SET supplier_id = $1, note = $2, updated_at = NOW() WHERE id = $3",
+		po.SupplierID, po.Note, po.ID)
+	if err != nil {
+		return fmt.Errorf("update error %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM purchase_order_lines WHERE purchase_order_id = $1", po.ID); err != nil {
+		return fmt.Errorf("update error %w", err)
+	}
+
+	if err := insertPurchaseOrderLines(tx, po.ID, po.Lines); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetStatus moves an order to status, provided its current status is one of from.
+func (repo *PurchaseOrderRepository) SetStatus(id int, status string, from ...string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPurchaseOrder(tx, id, from...); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE purchase_orders SET status = $1, updated_at = NOW() WHERE id = $2", status, id)
+	if err != nil {
+		return fmt.Errorf("update error %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func lockPurchaseOrder(tx *sql.Tx, id int, allowed ...string) (string, error) {
+	var status string
+	err := tx.QueryRow("SELECT status FROM purchase_orders WHERE id = $1 FOR UPDATE", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", errors.New("purchase order tidak ditemukan")
+	}
+	if err != nil {
+		return "", fmt.Errorf("database error %w", err)
+	}
+
+	for _, s := range allowed {
+		if s == status {
+			return status, nil
+		}
+	}
+
+	return status, fmt.Errorf("%w: order is %s", ErrPurchaseOrderStatus, status)
+}
+
+// Receive books a goods receipt against a sent order, adding the received
+// quantities to stock through the ledger. The order becomes received once
+// every line is complete (or req.Close is set), otherwise partially received.
+func (repo *PurchaseOrderRepository) Receive(poID int, req models.GoodsReceiptRequest, user string) (*models.GoodsReceipt, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = lockPurchaseOrder(tx, poID, models.POStatusSent, models.POStatusPartiallyReceived)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &models.GoodsReceipt{Note: req.Note, ReceivedBy: user}
+	err = tx.QueryRow(`INSERT INTO goods_receipts (purchase_order_id, note, received_by)
+			VALUES ($1, $2, NULLIF($3, '')) RETURNING id, received_at`, poID, req.Note, user).
+		Scan(&receipt.ID, &receipt.ReceivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create receipt error %w", err)
+	}
+
+	for _, line := range req.Lines {
+		var productID, ordered, received, costPrice int
+		var trackBatches, archived bool
+		err := tx.QueryRow(`SELECT l.product_id, l.quantity, l.received_qty, l.cost_price, p.track_batches,
+					p.deleted_at IS NOT NULL
+				FROM purchase_order_lines l JOIN products p ON p.id = l.product_id
+				WHERE l.id = $1 AND l.purchase_order_id = $2 FOR UPDATE OF l, p`, line.LineID, poID).
+			Scan(&productID, &ordered, &received, &costPrice, &trackBatches, &archived)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("line id %d is not part of purchase order %d", line.LineID, poID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("database error %w", err)
+		}
+
+		if archived {
+			return nil, fmt.Errorf("%w: line id %d, product id %d", ErrArchivedProduct, line.LineID, productID)
+		}
+
+		if received+line.Quantity > ordered && !req.AllowOverReceipt {
+			return nil, fmt.Errorf("%w: line id %d ordered %d, already received %d, receiving %d",
+				ErrOverReceipt, line.LineID, ordered, received, line.Quantity)
+		}
+
+		_, err = tx.Exec("UPDATE purchase_order_lines SET received_qty = received_qty + $1 WHERE id = $2",
+			line.Quantity, line.LineID)
+		if err != nil {
+			return nil, fmt.Errorf("update line error %w", err)
+		}
+
+		var batchID *int
+		if trackBatches {
+			if line.LotNumber == "" {
+				return nil, fmt.Errorf("line id %d: lot_number is required for batch-tracked products", line.LineID)
+			}
+			id, err := receiveBatch(tx, productID, line.LotNumber, line.ExpiryDate, line.Quantity)
+			if err != nil {
+				return nil, err
+			}
+			batchID = &id
+		}
+
+		_, err = tx.Exec(`INSERT INTO goods_receipt_lines (goods_receipt_id, purchase_order_line_id, quantity, batch_id)
+				VALUES ($1, $2, $3, $4)`, receipt.ID, line.LineID, line.Quantity, batchID)
+		if err != nil {
+			return nil, fmt.Errorf("create receipt line error %w", err)
+		}
+
+		// Moving weighted average over stock on hand; negative stock
+		// (overselling) carries no cost weight.
+		_, err = tx.Exec(`UPDATE products SET cost_price = ROUND(
+					(GREATEST(stock, 0)::numeric * cost_price + $1::numeric * $2) / (GREATEST(stock, 0) + $1))
+				WHERE id = $3`, line.Quantity, costPrice, productID)
+		if err != nil {
+			return nil, fmt.Errorf("cost price error %w", err)
+		}
+
+		err = applyStockMovement(tx, &models.StockMovement{
+			ProductID: productID,
+			Type:      models.MovementPurchaseReceipt,
+			Quantity:  line.Quantity,
+			Reference: fmt.Sprintf("purchase_order:%d", poID),
+			User:      user,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		line.ProductID = productID
+		receipt.Lines = append(receipt.Lines, line)
+	}
+
+	var outstanding int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM purchase_order_lines
+			WHERE purchase_order_id = $1 AND received_qty < quantity`, poID).Scan(&outstanding)
+	if err != nil {
+		return nil, fmt.Errorf("database error %w", err)
+	}
+
+	status := models.POStatusPartiallyReceived
+	if outstanding == 0 || req.Close {
+		status = models.POStatusReceived
+	}
+
+	_, err = tx.Exec("UPDATE purchase_orders SET status = $1, updated_at = NOW() WHERE id = $2", status, poID)
+	if err != nil {
+		return nil, fmt.Errorf("update error %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return receipt, nil
+}
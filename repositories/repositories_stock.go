@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"kasir-api/models"
+)
+
+var ErrInsufficientStock = errors.New("stok tidak mencukupi")
+
+type StockRepository struct {
+	db *sql.DB
+}
+
+func NewStockRepository(db *sql.DB) *StockRepository {
+	return &StockRepository{db: db}
+}
+
+// applyStockMovement changes the product's stock by m.Quantity and appends m
+// to the ledger. It must run inside the caller's transaction so that stock and
+// ledger can never diverge; every stock change goes through here. Stock is not
+// part of a product edit, so the product's version stays as it is.
+func applyStockMovement(tx *sql.Tx, m *models.StockMovement) error {
+	// Recovering above the minimum re-arms the low-stock alert.
+	query := `UPDATE products SET stock = stock + $1,
+				low_stock_alerted_at = CASE WHEN stock + $1 > min_stock THEN NULL ELSE low_stock_alerted_at END
+			WHERE id = $2 RETURNING stock, track_batches`
+	var trackBatches bool
+	err := tx.QueryRow(query, m.Quantity, m.ProductID).Scan(&m.Balance, &trackBatches)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("product id %d not found", m.ProductID)
+	}
+	if err != nil {
+		return fmt.Errorf("stock update error %w", err)
+	}
+
+	// Sales pick their batches themselves; any other decrease of a tracked
+	// product writes off its batches, expired ones first.
+	if trackBatches && m.Quantity < 0 && m.Type != models.MovementSale {
+		if err := releaseFromBatches(tx, m.ProductID, -m.Quantity); err != nil {
+			return err
+		}
+	}
+
+	query = `INSERT INTO stock_movements (product_id, type, quantity, balance, reference, reason, note, user_name)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''))
+			RETURNING id, created_at`
+	err = tx.QueryRow(query, m.ProductID, m.Type, m.Quantity, m.Balance, m.Reference, m.Reason, m.Note, m.User).
+		Scan(&m.ID, &m.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("stock movement error %w", err)
+	}
+
+	return nil
+}
+
+func (repo *StockRepository) GetMovements(productID int) ([]models.StockMovement, error) {
+	query := `SELECT id, product_id, type, quantity, balance, COALESCE(reference, ''),
+				COALESCE(reason, ''), COALESCE(note, ''), COALESCE(user_name, ''), created_at
+			FROM stock_movements WHERE product_id = $1 ORDER BY id`
+
+	rows, err := repo.db.Query(query, productID)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	movements := make([]models.StockMovement, 0)
+	for rows.Next() {
+		var m models.StockMovement
+		err := rows.Scan(&m.ID, &m.ProductID, &m.Type, &m.Quantity, &m.Balance, &m.Reference,
+			&m.Reason, &m.Note, &m.User, &m.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		movements = append(movements, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return movements, nil
+}
+
+// GetLedger returns the product's stock next to the stock derived from its
+// ledger, so any drift between the two is visible.
+func (repo *StockRepository) GetLedger(productID int) (*models.StockLedger, error) {
+	query := `SELECT p.id, p.stock, COALESCE(SUM(m.quantity), 0)
+			FROM products p LEFT JOIN stock_movements m ON m.product_id = p.id
+			WHERE p.id = $1
+			GROUP BY p.id, p.stock`
+
+	var ledger models.StockLedger
+	err := repo.db.QueryRow(query, productID).Scan(&ledger.ProductID, &ledger.Stock, &ledger.LedgerStock)
+	if err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error %w", err)
+	}
+
+	ledger.Difference = ledger.Stock - ledger.LedgerStock
+	ledger.Movements, err = repo.GetMovements(productID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ledger, nil
+}
+
+// AdjustStock applies a manual stock correction. Stock may not go below zero.
+func (repo *StockRepository) AdjustStock(productID int, adj models.StockAdjustment, user string) (*models.StockMovement, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var stock int
+	err = tx.QueryRow("SELECT stock FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", productID).
+		Scan(&stock)
+	if err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error %w", err)
+	}
+
+	if stock+adj.Delta < 0 {
+		return nil, ErrInsufficientStock
+	}
+
+	movement := &models.StockMovement{
+		ProductID: productID,
+		Type:      models.MovementAdjustment,
+		Quantity:  adj.Delta,
+		Reason:    adj.Reason,
+		Note:      adj.Note,
+		User:      user,
+	}
+	if err := applyStockMovement(tx, movement); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return movement, nil
+}
@@ -0,0 +1,277 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"kasir-api/models"
+)
+
+var (
+	ErrStockCountNotFound = errors.New("stock count tidak ditemukan")
+	ErrStockCountClosed   = errors.New("stock count is no longer open")
+)
+
+type StockCountRepository struct {
+	db *sql.DB
+}
+
+func NewStockCountRepository(db *sql.DB) *StockCountRepository {
+	return &StockCountRepository{db: db}
+}
+
+// Start opens a count and snapshots the expected stock of every product in
+// scope. Product rows are share-locked first so that in-flight sales either
+// land fully before the snapshot or fully after started_at.
+func (repo *StockCountRepository) Start(count *models.StockCount) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := activeCategory(tx, count.CategoryID); err != nil {
+		return err
+	}
+
+	scope := `WHERE ($1::int IS NULL OR category_id IN (SELECT id FROM category_paths WHERE $1 = ANY(path_ids)))
+			AND deleted_at IS NULL`
+
+	_, err = tx.Exec("SELECT id FROM products "+scope+" FOR SHARE", count.CategoryID)
+	if err != nil {
+		return fmt.Errorf("lock error %w", err)
+	}
+
+	query := `INSERT INTO stock_counts (category_id, note, started_by, started_at)
+			VALUES ($1, $2, NULLIF($3, ''), clock_timestamp()) RETURNING id, status, started_at`
+	err = tx.QueryRow(query, count.CategoryID, count.Note, count.StartedBy).
+		Scan(&count.ID, &count.Status, &count.StartedAt)
+	if err != nil {
+		return fmt.Errorf("create error %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO stock_count_lines (stock_count_id, product_id, snapshot)
+			SELECT $2, id, stock FROM products `+scope, count.CategoryID, count.ID)
+	if err != nil {
+		return fmt.Errorf("snapshot error %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (repo *StockCountRepository) GetAll() ([]models.StockCount, error) {
+	query := `SELECT id, category_id, status, note, COALESCE(started_by, ''), started_at,
+				COALESCE(posted_by, ''), posted_at
+			FROM stock_counts ORDER BY id DESC`
+
+	rows, err := repo.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]models.StockCount, 0)
+	for rows.Next() {
+		var c models.StockCount
+		err := rows.Scan(&c.ID, &c.CategoryID, &c.Status, &c.Note, &c.StartedBy, &c.StartedAt,
+			&c.PostedBy, &c.PostedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return counts, nil
+}
+
+func (repo *StockCountRepository) GetByID(id int) (*models.StockCount, error) {
+	return getStockCount(repo.db, id, false)
+}
+
+// SubmitItems records counted quantities. Devices may submit overlapping
+// batches; the latest count of a product wins.
+func (repo *StockCountRepository) SubmitItems(id int, items []models.StockCountItem, user string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOpenStockCount(tx, id); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		result, err := tx.Exec(`UPDATE stock_count_lines
+				SET counted = $1, counted_by = NULLIF($2, ''), counted_at = clock_timestamp()
+				WHERE stock_count_id = $3 AND product_id = $4`,
+			item.Counted, user, id, item.ProductID)
+		if err != nil {
+			return fmt.Errorf("update error %w", err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected error: %w", err)
+		}
+		if rows == 0 {
+			return fmt.Errorf("product id %d is not part of this stock count", item.ProductID)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Post turns every counted variance into an opname movement and closes the
+// session, all in one transaction. Uncounted products are left untouched.
+func (repo *StockCountRepository) Post(id int, user string) (*models.StockCount, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockOpenStockCount(tx, id); err != nil {
+		return nil, err
+	}
+
+	count, err := getStockCount(tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range count.Lines {
+		if line.Variance == nil || *line.Variance == 0 {
+			continue
+		}
+
+		err = applyStockMovement(tx, &models.StockMovement{
+			ProductID: line.ProductID,
+			Type:      models.MovementOpname,
+			Quantity:  *line.Variance,
+			Reference: fmt.Sprintf("stock_count:%d", id),
+			User:      user,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(`UPDATE stock_counts SET status = 'posted', posted_by = NULLIF($1, ''), posted_at = NOW()
+			WHERE id = $2 RETURNING status, posted_at`, user, id).Scan(&count.Status, &count.PostedAt)
+	if err != nil {
+		return nil, fmt.Errorf("update error %w", err)
+	}
+	count.PostedBy = user
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return count, nil
+}
+
+func (repo *StockCountRepository) Cancel(id int) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOpenStockCount(tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE stock_counts SET status = 'cancelled' WHERE id = $1", id); err != nil {
+		return fmt.Errorf("update error %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func lockOpenStockCount(tx *sql.Tx, id int) error {
+	var status string
+	err := tx.QueryRow("SELECT status FROM stock_counts WHERE id = $1 FOR UPDATE", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrStockCountNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("database error %w", err)
+	}
+
+	if status != models.StockCountOpen {
+		return ErrStockCountClosed
+	}
+
+	return nil
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getStockCount loads a session with its lines. A line's expected stock is
+// its snapshot plus every ledger movement after the session started and up to
+// the moment it was counted (or the session was posted, or now).
+func getStockCount(q queryer, id int, countedOnly bool) (*models.StockCount, error) {
+	query := `SELECT id, category_id, status, note, COALESCE(started_by, ''), started_at,
+				COALESCE(posted_by, ''), posted_at
+			FROM stock_counts WHERE id = $1`
+
+	var c models.StockCount
+	err := q.QueryRow(query, id).Scan(&c.ID, &c.CategoryID, &c.Status, &c.Note, &c.StartedBy, &c.StartedAt,
+		&c.PostedBy, &c.PostedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrStockCountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error %w", err)
+	}
+
+	linesQuery := `SELECT l.product_id, p.name, l.snapshot,
+				l.snapshot + COALESCE((
+					SELECT SUM(m.quantity) FROM stock_movements m
+					WHERE m.product_id = l.product_id
+						AND m.created_at > c.started_at
+						AND m.created_at <= COALESCE(l.counted_at, c.posted_at, clock_timestamp())
+				), 0),
+				l.counted, COALESCE(l.counted_by, ''), l.counted_at
+			FROM stock_count_lines l
+			JOIN stock_counts c ON c.id = l.stock_count_id
+			JOIN products p ON p.id = l.product_id
+			WHERE l.stock_count_id = $1 AND ($2 = false OR l.counted IS NOT NULL)
+			ORDER BY p.name, l.product_id`
+
+	rows, err := q.Query(linesQuery, id, countedOnly)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	c.Lines = make([]models.StockCountLine, 0)
+	for rows.Next() {
+		var l models.StockCountLine
+		err := rows.Scan(&l.ProductID, &l.ProductName, &l.Snapshot, &l.Expected,
+			&l.Counted, &l.CountedBy, &l.CountedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan error %w", err)
+		}
+
+		if l.Counted != nil {
+			variance := *l.Counted - l.Expected
+			l.Variance = &variance
+		}
+		c.Lines = append(c.Lines, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error %w", err)
+	}
+
+	return &c, nil
+}
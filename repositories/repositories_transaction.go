@@ -2,8 +2,22 @@ package repositories
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"kasir-api/models"
+	"math"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrEmptyCheckout   = errors.New("items cannot be empty")
+	ErrInvalidQuantity = errors.New("quantity must be greater than 0")
+	ErrBarcodeNotFound = errors.New("barcode not found")
+	ErrUnitNotFound    = errors.New("unit not found")
+	ErrProductDeleted  = errors.New("product has been deleted and cannot be sold")
+	ErrBatchNotTracked = errors.New("product does not track batches")
 )
 
 type TransactionRepository struct {
@@ -14,10 +28,20 @@ func NewTransactionRepository(db *sql.DB) *TransactionRepository {
 	return &TransactionRepository{db: db}
 }
 
-func (repo *TransactionRepository) CreateTransaction(items []models.CheckoutItem) (*models.Transaction, error) {
+// inReportRange selects the transactions t of a ReportRange, passed as $1
+// (From), $2 (To) and $3 (OutletID).
+const inReportRange = `t.created_at >= $1 AND t.created_at < $2 AND ($3::int IS NULL OR t.outlet_id = $3)`
+
+func reportRangeArgs(rng models.ReportRange, args ...interface{}) []interface{} {
+	return append([]interface{}{rng.From, rng.To, rng.OutletID}, args...)
+}
+
+// CreateTransaction records a sale at the outlet. today is the outlet's
+// business date (YYYY-MM-DD), which batches must not have expired by.
+func (repo *TransactionRepository) CreateTransaction(items []models.CheckoutItem, outletID *int, today, user string) (*models.Transaction, error) {
 
 	if len(items) == 0 {
-		return nil, fmt.Errorf("items cannot be empty")
+		return nil, ErrEmptyCheckout
 	}
 
 	var res *models.Transaction
@@ -27,41 +51,116 @@ func (repo *TransactionRepository) CreateTransaction(items []models.CheckoutItem
 	}
 	defer tx.Rollback()
 
+	if outletID != nil {
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM outlets WHERE id = $1)", *outletID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: id %d", ErrOutletNotFound, *outletID)
+		}
+	}
+
 	totalAmount := 0
 	details := make([]models.TransactionDetail, 0)
 
 	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: product id %d", ErrInvalidQuantity, item.ProductID)
+		}
+
+		if item.Barcode != "" {
+			// A barcode is either a selling unit's or a product's own
+			var unitID *int
+			err := tx.QueryRow(`SELECT u.id, u.product_id FROM product_units u
+						JOIN products p ON p.id = u.product_id
+						WHERE u.barcode = $1 AND p.deleted_at IS NULL
+					UNION ALL
+					SELECT NULL, id FROM products WHERE barcode = $1 AND deleted_at IS NULL
+					LIMIT 1`, item.Barcode).
+				Scan(&unitID, &item.ProductID)
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("%w: %s", ErrBarcodeNotFound, item.Barcode)
+			}
+			if err != nil {
+				return nil, err
+			}
+			item.UnitID = unitID
+		}
+
 		var productName string
-		var productID, price, stock int
+		var productID, price, costPrice, stock int
+		var categoryID *int
+		var trackBatches, deleted bool
 
-		err := tx.QueryRow("SELECT id, name, price, stock FROM products WHERE id=$1", item.ProductID).
-			Scan(&productID, &productName, &price, &stock)
+		err := tx.QueryRow(`SELECT id, name, price, cost_price, stock, track_batches, category_id, deleted_at IS NOT NULL
+				FROM products WHERE id=$1 FOR UPDATE`, item.ProductID).
+			Scan(&productID, &productName, &price, &costPrice, &stock, &trackBatches, &categoryID, &deleted)
 
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("product id %d not found", item.ProductID)
+			return nil, fmt.Errorf("%w: id %d", ErrProductNotFound, item.ProductID)
 		}
 		if err != nil {
 			return nil, err
 		}
+		if deleted {
+			return nil, fmt.Errorf("%w: product id %d", ErrProductDeleted, productID)
+		}
 
-		subtotal := item.Quantity * price
-		totalAmount += subtotal
+		unitName := ""
+		conversion := 1
+		if item.UnitID != nil {
+			err := tx.QueryRow("SELECT name, conversion, price FROM product_units WHERE id=$1 AND product_id=$2",
+				*item.UnitID, productID).Scan(&unitName, &conversion, &price)
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("%w: unit id %d for product id %d", ErrUnitNotFound, *item.UnitID, productID)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
 
-		_, err = tx.Exec("UPDATE products SET stock = stock - $1 WHERE id = $2", item.Quantity, productID)
+		categoryPath, err := snapshotCategoryPath(tx, categoryID)
 		if err != nil {
 			return nil, err
 		}
 
+		baseQuantity := item.Quantity * conversion
+		subtotal := item.Quantity * price
+		totalAmount += subtotal
+
+		var batches []models.DetailBatch
+		if trackBatches {
+			batches, err = sellFromBatches(tx, productID, item.BatchID, baseQuantity, today)
+			if err != nil {
+				return nil, err
+			}
+		} else if item.BatchID != nil {
+			return nil, fmt.Errorf("%w: product id %d", ErrBatchNotTracked, productID)
+		}
+
 		details = append(details, models.TransactionDetail{
-			ProductID:   productID,
-			ProductName: productName,
-			Quantity:    item.Quantity,
-			Subtotal:    subtotal,
+			ProductID:    productID,
+			ProductName:  productName,
+			UnitID:       item.UnitID,
+			UnitName:     unitName,
+			Quantity:     item.Quantity,
+			Conversion:   conversion,
+			BaseQuantity: baseQuantity,
+			Subtotal:     subtotal,
+			CostPrice:    costPrice,
+			CategoryID:   categoryID,
+			CategoryPath: categoryPath,
+			Batches:      batches,
 		})
+		if len(categoryPath) > 0 {
+			details[len(details)-1].CategoryName = categoryPath[len(categoryPath)-1].Name
+		}
 	}
 
 	var transactionID int
-	err = tx.QueryRow("INSERT INTO transactions (total_amount) VALUES ($1) RETURNING ID", totalAmount).
+	err = tx.QueryRow("INSERT INTO transactions (total_amount, outlet_id) VALUES ($1, $2) RETURNING ID", totalAmount, outletID).
 		Scan(&transactionID)
 	if err != nil {
 		return nil, err
@@ -69,9 +168,42 @@ func (repo *TransactionRepository) CreateTransaction(items []models.CheckoutItem
 
 	for i := range details {
 		details[i].TransactionID = transactionID
-		_, err = tx.Exec(
-			"INSERT INTO transaction_details (transaction_id, product_id, quantity, subtotal) VALUES ($1, $2, $3, $4)",
-			transactionID, details[i].ProductID, details[i].Quantity, details[i].Subtotal)
+
+		pathIDs := make(pq.Int64Array, 0, len(details[i].CategoryPath))
+		pathNames := make(pq.StringArray, 0, len(details[i].CategoryPath))
+		for _, c := range details[i].CategoryPath {
+			pathIDs = append(pathIDs, int64(c.ID))
+			pathNames = append(pathNames, c.Name)
+		}
+
+		err = tx.QueryRow(
+			`INSERT INTO transaction_details
+				(transaction_id, product_id, unit_id, unit_name, quantity, conversion, subtotal, cost_price,
+				category_id, category_name, category_path_ids, category_path_names)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, NULLIF($10, ''), $11, $12) RETURNING id`,
+			transactionID, details[i].ProductID, details[i].UnitID, details[i].UnitName,
+			details[i].Quantity, details[i].Conversion, details[i].Subtotal, details[i].CostPrice,
+			details[i].CategoryID, details[i].CategoryName, pathIDs, pathNames).Scan(&details[i].ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range details[i].Batches {
+			_, err = tx.Exec(
+				"INSERT INTO transaction_detail_batches (transaction_detail_id, batch_id, quantity) VALUES ($1, $2, $3)",
+				details[i].ID, b.BatchID, b.Quantity)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = applyStockMovement(tx, &models.StockMovement{
+			ProductID: details[i].ProductID,
+			Type:      models.MovementSale,
+			Quantity:  -details[i].BaseQuantity,
+			Reference: fmt.Sprintf("transaction:%d", transactionID),
+			User:      user,
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -90,82 +222,400 @@ func (repo *TransactionRepository) CreateTransaction(items []models.CheckoutItem
 	return res, nil
 }
 
-func (repo *TransactionRepository) GetTodayReport() (*models.SalesReport, error) {
+// snapshotCategoryPath returns the path down to a product's category as it
+// is now, for recording with the sale.
+func snapshotCategoryPath(tx *sql.Tx, categoryID *int) ([]models.CategoryCrumb, error) {
+	if categoryID == nil {
+		return nil, nil
+	}
+
+	var ids pq.Int64Array
+	var names pq.StringArray
+	err := tx.QueryRow("SELECT path_ids, path_names FROM category_paths WHERE id = $1", *categoryID).Scan(&ids, &names)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([]models.CategoryCrumb, len(ids))
+	for i := range ids {
+		path[i] = models.CategoryCrumb{ID: int(ids[i]), Name: names[i]}
+	}
+	return path, nil
+}
+
+// GetReport totals revenue, transactions and cost of goods sold over rng.
+func (repo *TransactionRepository) GetReport(rng models.ReportRange) (*models.SalesReport, error) {
 	var report models.SalesReport
 
 	query := `
 		SELECT 
 			COALESCE(SUM(total_amount), 0) as total_revenue,
 			COUNT(*) as total_transaksi
-		FROM transactions
-		WHERE DATE(created_at) = CURRENT_DATE
-	`
+		FROM transactions t
+		WHERE ` + inReportRange
 
-	err := repo.db.QueryRow(query).Scan(&report.TotalRevenue, &report.TotalTransaksi)
+	err := repo.db.QueryRow(query, reportRangeArgs(rng)...).Scan(&report.TotalRevenue, &report.TotalTransaksi)
 	if err != nil {
 		return nil, err
 	}
+	if report.TotalTransaksi > 0 {
+		report.AverageBasket = int(math.Round(float64(report.TotalRevenue) / float64(report.TotalTransaksi)))
+	}
 
-	topProductQuery := `
-		SELECT 
+	topProduct, err := repo.topProduct(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	cogsQuery := `
+		SELECT COALESCE(SUM(td.cost_price::bigint * td.quantity * td.conversion), 0) as total_cogs
+		FROM transaction_details td
+		JOIN transactions t ON td.transaction_id = t.id
+		WHERE ` + inReportRange
+
+	err = repo.db.QueryRow(cogsQuery, reportRangeArgs(rng)...).Scan(&report.TotalCOGS)
+	if err != nil {
+		return nil, err
+	}
+
+	report.GrossProfit = int64(report.TotalRevenue) - report.TotalCOGS
+	report.MarginPercent = marginPercent(report.GrossProfit, int64(report.TotalRevenue))
+
+	report.ProdukTerlaris = topProduct
+
+	return &report, nil
+}
+
+// GetProductProfit reports revenue, cost and gross profit per product.
+func (repo *TransactionRepository) GetProductProfit(rng models.ReportRange) ([]models.ProductProfit, error) {
+	query := `
+		SELECT
+			td.product_id,
 			p.name,
-			COALESCE(SUM(td.quantity), 0) as qty_terjual
+			COALESCE(SUM(td.quantity * td.conversion), 0) as qty_sold,
+			COALESCE(SUM(td.subtotal), 0) as revenue,
+			COALESCE(SUM(td.cost_price::bigint * td.quantity * td.conversion), 0) as cogs
 		FROM transaction_details td
 		JOIN transactions t ON td.transaction_id = t.id
 		JOIN products p ON td.product_id = p.id
-		WHERE DATE(t.created_at) = CURRENT_DATE
-		GROUP BY p.id, p.name
-		ORDER BY qty_terjual DESC
-		LIMIT 1
+		WHERE ` + inReportRange + `
+		GROUP BY td.product_id, p.name
+		ORDER BY SUM(td.subtotal) - SUM(td.cost_price::bigint * td.quantity * td.conversion) DESC, td.product_id
 	`
 
-	var topProduct models.TopProduct
-	err = repo.db.QueryRow(topProductQuery).Scan(&topProduct.Nama, &topProduct.QtyTerjual)
-	if err != nil && err != sql.ErrNoRows {
+	rows, err := repo.db.Query(query, reportRangeArgs(rng)...)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	report.ProdukTerlaris = topProduct
+	profits := make([]models.ProductProfit, 0)
+	for rows.Next() {
+		var p models.ProductProfit
+		err := rows.Scan(&p.ProductID, &p.ProductName, &p.QtySold, &p.Revenue, &p.COGS)
+		if err != nil {
+			return nil, err
+		}
 
-	return &report, nil
+		p.GrossProfit = int64(p.Revenue) - p.COGS
+		p.MarginPercent = marginPercent(p.GrossProfit, int64(p.Revenue))
+		profits = append(profits, p)
+	}
+
+	return profits, rows.Err()
 }
 
-func (repo *TransactionRepository) GetReportByDateRange(startDate, endDate string) (*models.SalesReport, error) {
-	var report models.SalesReport
+// productRankMetrics maps the ranking criteria to the measure ranked on and
+// the one that breaks ties.
+var productRankMetrics = map[string][2]string{
+	"quantity": {"qty", "revenue"},
+	"revenue":  {"revenue", "qty"},
+}
+
+// GetProductRanking returns the limit best and worst products by quantity or
+// revenue. Ties are broken by the other measure, then by name and ID, so the
+// order is stable.
+func (repo *TransactionRepository) GetProductRanking(rng models.ReportRange, by string, limit int) (*models.ProductRankingReport, error) {
+	metric, ok := productRankMetrics[by]
+	if !ok {
+		return nil, fmt.Errorf("invalid ranking %q", by)
+	}
 
 	query := `
-		SELECT 
-			COALESCE(SUM(total_amount), 0) as total_revenue,
-			COUNT(*) as total_transaksi
-		FROM transactions
-		WHERE DATE(created_at) >= $1 AND DATE(created_at) <= $2
+		WITH sales AS (
+			SELECT
+				td.product_id,
+				SUM(td.quantity * td.conversion) AS qty,
+				SUM(td.subtotal) AS revenue
+			FROM transaction_details td
+			JOIN transactions t ON td.transaction_id = t.id
+			WHERE ` + inReportRange + `
+			GROUP BY td.product_id
+		),
+		ranked AS (
+			SELECT
+				p.id,
+				p.name,
+				COALESCE(s.qty, 0) AS qty,
+				COALESCE(s.revenue, 0) AS revenue
+			FROM products p LEFT JOIN sales s ON s.product_id = p.id
+			WHERE p.deleted_at IS NULL OR s.product_id IS NOT NULL
+		),
+		numbered AS (
+			SELECT *,
+				ROW_NUMBER() OVER (ORDER BY ` + metric[0] + ` DESC, ` + metric[1] + ` DESC, name, id) AS top_rank,
+				ROW_NUMBER() OVER (ORDER BY ` + metric[0] + `, ` + metric[1] + `, name, id) AS bottom_rank,
+				SUM(revenue) OVER () AS total_revenue
+			FROM ranked
+		)
+		SELECT id, name, qty, revenue, top_rank, bottom_rank, total_revenue
+		FROM numbered
+		WHERE top_rank <= $4 OR bottom_rank <= $4
+		ORDER BY top_rank
 	`
 
-	err := repo.db.QueryRow(query, startDate, endDate).Scan(&report.TotalRevenue, &report.TotalTransaksi)
+	rows, err := repo.db.Query(query, reportRangeArgs(rng, limit)...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	topProductQuery := `
-		SELECT 
-			p.name,
-			COALESCE(SUM(td.quantity), 0) as qty_terjual
+	report := &models.ProductRankingReport{
+		By:     by,
+		Top:    make([]models.ProductRanking, 0, limit),
+		Bottom: make([]models.ProductRanking, 0, limit),
+	}
+	for rows.Next() {
+		var p models.ProductRanking
+		var topRank, bottomRank int
+		err := rows.Scan(&p.ProductID, &p.ProductName, &p.QtySold, &p.Revenue, &topRank, &bottomRank, &report.TotalRevenue)
+		if err != nil {
+			return nil, err
+		}
+
+		p.RevenueShare = percentOf(int64(p.Revenue), int64(report.TotalRevenue))
+		if topRank <= limit {
+			p.Rank = topRank
+			report.Top = append(report.Top, p)
+		}
+		if bottomRank <= limit {
+			p.Rank = bottomRank
+			report.Bottom = append(report.Bottom, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Rows come best first; the bottom list reads worst first
+	sort.Slice(report.Bottom, func(i, j int) bool { return report.Bottom[i].Rank < report.Bottom[j].Rank })
+
+	return report, nil
+}
+
+// topProduct is the best seller by quantity, or empty when nothing sold.
+func (repo *TransactionRepository) topProduct(rng models.ReportRange) (models.TopProduct, error) {
+	top, err := repo.GetTopSellers(rng, 1)
+	if err != nil || len(top) == 0 {
+		return models.TopProduct{}, err
+	}
+	return models.TopProduct{Nama: top[0].ProductName, QtyTerjual: top[0].QtySold}, nil
+}
+
+// GetTopSellers returns the limit best sellers by quantity. It breaks ties
+// like GetProductRanking but only looks at what sold, so it needs neither the
+// whole catalogue nor the bottom of the list. RevenueShare is left out.
+func (repo *TransactionRepository) GetTopSellers(rng models.ReportRange, limit int) ([]models.ProductRanking, error) {
+	query := `
+		SELECT p.id, p.name, SUM(td.quantity * td.conversion) AS qty, SUM(td.subtotal) AS revenue
 		FROM transaction_details td
 		JOIN transactions t ON td.transaction_id = t.id
 		JOIN products p ON td.product_id = p.id
-		WHERE DATE(t.created_at) >= $1 AND DATE(t.created_at) <= $2
+		WHERE ` + inReportRange + `
 		GROUP BY p.id, p.name
-		ORDER BY qty_terjual DESC
-		LIMIT 1
+		ORDER BY qty DESC, revenue DESC, p.name, p.id
+		LIMIT $4
 	`
 
-	var topProduct models.TopProduct
-	err = repo.db.QueryRow(topProductQuery, startDate, endDate).Scan(&topProduct.Nama, &topProduct.QtyTerjual)
-	if err != nil && err != sql.ErrNoRows {
+	rows, err := repo.db.Query(query, reportRangeArgs(rng, limit)...)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	report.ProdukTerlaris = topProduct
+	top := make([]models.ProductRanking, 0, limit)
+	for rows.Next() {
+		p := models.ProductRanking{Rank: len(top) + 1}
+		if err := rows.Scan(&p.ProductID, &p.ProductName, &p.QtySold, &p.Revenue); err != nil {
+			return nil, err
+		}
+		top = append(top, p)
+	}
 
-	return &report, nil
+	return top, rows.Err()
+}
+
+// GetCategorySales aggregates sales by the category each product had when it
+// was sold. With level > 0 sales roll up to
+// their ancestor at that depth of the tree (1 = top level); categories above
+// that depth stay as they are. Names are the latest ones sold under.
+func (repo *TransactionRepository) GetCategorySales(rng models.ReportRange, level int) ([]models.CategorySales, error) {
+	query := `
+		WITH sales AS (
+			SELECT
+				td.category_path_ids[LEAST(NULLIF($4, 0), cardinality(td.category_path_ids))] AS category_id,
+				td.category_path_names[LEAST(NULLIF($4, 0), cardinality(td.category_path_names))] AS category_name,
+				td.transaction_id,
+				td.subtotal,
+				td.quantity * td.conversion AS quantity,
+				t.created_at
+			FROM transaction_details td
+			JOIN transactions t ON td.transaction_id = t.id
+			WHERE ` + inReportRange + `
+		)
+		SELECT
+			category_id,
+			COALESCE((array_agg(category_name ORDER BY created_at DESC))[1], ''),
+			SUM(subtotal),
+			SUM(quantity),
+			COUNT(DISTINCT transaction_id)
+		FROM sales
+		GROUP BY category_id
+		ORDER BY SUM(subtotal) DESC, category_id NULLS LAST
+	`
+
+	rows, err := repo.db.Query(query, reportRangeArgs(rng, level)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := make([]models.CategorySales, 0)
+	total := 0
+	for rows.Next() {
+		var c models.CategorySales
+		err := rows.Scan(&c.CategoryID, &c.CategoryName, &c.Revenue, &c.Quantity, &c.Transactions)
+		if err != nil {
+			return nil, err
+		}
+		total += c.Revenue
+		report = append(report, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report {
+		report[i].RevenueShare = percentOf(int64(report[i].Revenue), int64(total))
+	}
+
+	return report, nil
+}
+
+// GetSalesSeries returns sales per business day, week or month (groupBy)
+// in rng, with a row for every period even when nothing sold. Weeks and
+// months at the edges only count the days inside the range.
+func (repo *TransactionRepository) GetSalesSeries(rng models.ReportRange, groupBy string) ([]models.SalesPeriod, error) {
+	query := `
+		WITH periods AS (
+			SELECT generate_series(
+				date_trunc($4, $5::date::timestamp),
+				date_trunc($4, $6::date::timestamp),
+				('1 ' || $4)::interval)::date AS period
+		),
+		sales AS (
+			SELECT
+				date_trunc($4, (t.created_at AT TIME ZONE $7) - make_interval(hours => $8))::date AS period,
+				COUNT(*) AS transactions,
+				SUM(t.total_amount) AS revenue,
+				SUM((SELECT COALESCE(SUM(td.quantity * td.conversion), 0)
+					FROM transaction_details td WHERE td.transaction_id = t.id)) AS items
+			FROM transactions t
+			WHERE ` + inReportRange + `
+			GROUP BY 1
+		)
+		SELECT TO_CHAR(p.period, 'YYYY-MM-DD'), COALESCE(s.revenue, 0), COALESCE(s.transactions, 0), COALESCE(s.items, 0)
+		FROM periods p LEFT JOIN sales s ON s.period = p.period
+		ORDER BY p.period
+	`
+
+	rows, err := repo.db.Query(query, reportRangeArgs(rng, groupBy, rng.StartDate, rng.EndDate, rng.Timezone, rng.CutoverHour)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := make([]models.SalesPeriod, 0)
+	for rows.Next() {
+		var p models.SalesPeriod
+		if err := rows.Scan(&p.Period, &p.Revenue, &p.Transactions, &p.ItemsSold); err != nil {
+			return nil, err
+		}
+
+		if p.Transactions > 0 {
+			p.AverageBasket = int(math.Round(float64(p.Revenue) / float64(p.Transactions)))
+			p.AverageItems = math.Round(float64(p.ItemsSold)/float64(p.Transactions)*100) / 100
+		}
+		series = append(series, p)
+	}
+
+	return series, rows.Err()
+}
+
+// GetSalesHeatmap counts transactions and revenue per day of week and hour
+// of day, read on the clock in rng's timezone. Every cell is returned, empty
+// ones as zero.
+func (repo *TransactionRepository) GetSalesHeatmap(rng models.ReportRange) (*models.SalesHeatmap, error) {
+	query := `
+		WITH sales AS (
+			SELECT t.created_at AT TIME ZONE $4 AS local_time, t.total_amount
+			FROM transactions t
+			WHERE ` + inReportRange + `
+		)
+		SELECT d.dow, h.hour, COUNT(s.local_time), COALESCE(SUM(s.total_amount), 0)
+		FROM generate_series(1, 7) AS d(dow)
+			CROSS JOIN generate_series(0, 23) AS h(hour)
+			LEFT JOIN sales s ON EXTRACT(ISODOW FROM s.local_time) = d.dow
+				AND EXTRACT(HOUR FROM s.local_time) = h.hour
+		GROUP BY d.dow, h.hour
+		ORDER BY d.dow, h.hour
+	`
+
+	rows, err := repo.db.Query(query, reportRangeArgs(rng, rng.Timezone)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heatmap := &models.SalesHeatmap{
+		Timezone:  rng.Timezone,
+		StartDate: rng.StartDate,
+		EndDate:   rng.EndDate,
+		Cells:     make([]models.SalesHeatmapCell, 0, 7*24),
+	}
+	for rows.Next() {
+		var c models.SalesHeatmapCell
+		if err := rows.Scan(&c.DayOfWeek, &c.Hour, &c.Transactions, &c.Revenue); err != nil {
+			return nil, err
+		}
+		heatmap.Cells = append(heatmap.Cells, c)
+	}
+
+	return heatmap, rows.Err()
+}
+
+// marginPercent is profit as a percentage of revenue, rounded to two decimals.
+func marginPercent(profit, revenue int64) float64 {
+	return percentOf(profit, revenue)
+}
+
+// percentOf is part as a percentage of whole, rounded to two decimals.
+func percentOf(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*10000) / 100
 }
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"time"
+)
+
+// BusinessDay says when a store's trading day begins: CutoverHour o'clock in
+// Location, so sales before the cutover count towards the previous day.
+type BusinessDay struct {
+	Location    *time.Location
+	CutoverHour int
+}
+
+// NewBusinessDay checks timezone, an IANA zone name, and the cutover hour.
+func NewBusinessDay(timezone string, cutoverHour int) (BusinessDay, error) {
+	// Reports hand the zone name to Postgres too, which has no "Local"
+	if timezone == "" || timezone == "Local" {
+		return BusinessDay{}, invalid("timezone", "timezone is required, e.g. Asia/Jakarta")
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return BusinessDay{}, invalid("timezone", "unknown timezone %q", timezone)
+	}
+
+	if cutoverHour < 0 || cutoverHour > 23 {
+		return BusinessDay{}, invalid("day_cutover_hour", "day_cutover_hour must be between 0 and 23")
+	}
+
+	return BusinessDay{Location: location, CutoverHour: cutoverHour}, nil
+}
+
+// Start is the instant the business day on date begins.
+func (d BusinessDay) Start(date time.Time) time.Time {
+	y, m, day := date.Date()
+	return time.Date(y, m, day, d.CutoverHour, 0, 0, 0, d.Location)
+}
+
+// Date is the business date t falls in, as midnight UTC.
+func (d BusinessDay) Date(t time.Time) time.Time {
+	local := t.In(d.Location)
+	if local.Hour() < d.CutoverHour {
+		local = local.AddDate(0, 0, -1)
+	}
+
+	y, m, day := local.Date()
+	return time.Date(y, m, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBusinessDay(t *testing.T) {
+	tests := []struct {
+		timezone    string
+		cutoverHour int
+		wantField   string
+	}{
+		{"Asia/Jakarta", 0, ""},
+		{"Asia/Makassar", 23, ""},
+		{"", 0, "timezone"},
+		{"Local", 0, "timezone"},
+		{"Mars/Olympus_Mons", 0, "timezone"},
+		{"Asia/Jakarta", -1, "day_cutover_hour"},
+		{"Asia/Jakarta", 24, "day_cutover_hour"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewBusinessDay(tt.timezone, tt.cutoverHour)
+		if field := validationField(err); field != tt.wantField {
+			t.Errorf("NewBusinessDay(%q, %d) error = %v, want field %q", tt.timezone, tt.cutoverHour, err, tt.wantField)
+		}
+	}
+}
+
+func TestBusinessDayDate(t *testing.T) {
+	tests := []struct {
+		name        string
+		timezone    string
+		cutoverHour int
+		at          string
+		want        string
+	}{
+		{"UTC evening is next day in WIB", "Asia/Jakarta", 0, "2026-10-19T17:30:00Z", "2026-10-20"},
+		{"before midnight WIB", "Asia/Jakarta", 0, "2026-10-19T16:59:59Z", "2026-10-19"},
+		{"before cutover counts as previous day", "Asia/Makassar", 4, "2026-10-19T19:30:00Z", "2026-10-19"},
+		{"at cutover starts the day", "Asia/Makassar", 4, "2026-10-19T20:00:00Z", "2026-10-20"},
+		{"cutover across a month", "Asia/Makassar", 4, "2026-10-31T17:00:00Z", "2026-10-31"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day := mustBusinessDay(t, tt.timezone, tt.cutoverHour)
+			if got := day.Date(mustTime(t, tt.at)).Format("2006-01-02"); got != tt.want {
+				t.Errorf("Date(%s) = %s, want %s", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusinessDayStart(t *testing.T) {
+	day := mustBusinessDay(t, "Asia/Makassar", 4)
+	date := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	want := mustTime(t, "2026-10-18T20:00:00Z")
+	if got := day.Start(date); !got.Equal(want) {
+		t.Errorf("Start(2026-10-19) = %s, want %s", got.UTC(), want)
+	}
+}
+
+func mustBusinessDay(t *testing.T, timezone string, cutoverHour int) BusinessDay {
+	t.Helper()
+	day, err := NewBusinessDay(timezone, cutoverHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return day
+}
+
+func mustTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+// validationField is the field a ValidationError names, "" for no error and
+// "?" for an error of another kind.
+func validationField(err error) string {
+	if err == nil {
+		return ""
+	}
+	if verr, ok := err.(*ValidationError); ok {
+		return verr.Field
+	}
+	return "?"
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"kasir-api/models"
 	"kasir-api/repositories"
+	"strings"
+	"unicode/utf8"
 )
 
 type CategoryService struct {
@@ -13,22 +15,128 @@ func NewCategoryService(repo *repositories.CategoryRepository) *CategoryService
 	return &CategoryService{repo: repo}
 }
 
-func (s *CategoryService) GetAll() ([]models.Category, error) {
-	return s.repo.GetAll()
+func (s *CategoryService) GetAll(includeDeleted, withStats bool) ([]models.Category, error) {
+	return s.repo.GetAll(includeDeleted, withStats)
+}
+
+// Tree returns the active categories nested under their parents.
+func (s *CategoryService) Tree() ([]models.Category, error) {
+	categories, err := s.repo.GetAll(false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Children by parent ID; roots are under 0
+	children := make(map[int][]models.Category)
+	for _, c := range categories {
+		parent := 0
+		if c.ParentID != nil {
+			parent = *c.ParentID
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	var build func(parent int) []models.Category
+	build = func(parent int) []models.Category {
+		nodes := children[parent]
+		for i := range nodes {
+			nodes[i].Children = build(nodes[i].ID)
+		}
+		return nodes
+	}
+
+	tree := build(0)
+	if tree == nil {
+		tree = []models.Category{}
+	}
+	return tree, nil
 }
 
 func (s *CategoryService) Create(data *models.Category) error {
+	if err := validateCategory(data); err != nil {
+		return err
+	}
 	return s.repo.Create(data)
 }
 
-func (s *CategoryService) GetByID(id int) (*models.Category, error) {
-	return s.repo.GetByID(id)
+const (
+	maxCategoryName        = 100
+	maxCategoryDescription = 500
+)
+
+// validateCategory trims and checks a category. Names must also be unique
+// ignoring case, which the database enforces.
+func validateCategory(c *models.Category) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Description = strings.TrimSpace(c.Description)
+	if c.Name == "" {
+		return invalid("name", "name is required")
+	}
+	if utf8.RuneCountInString(c.Name) > maxCategoryName {
+		return invalid("name", "name cannot be longer than %d characters", maxCategoryName)
+	}
+	if utf8.RuneCountInString(c.Description) > maxCategoryDescription {
+		return invalid("description", "description cannot be longer than %d characters", maxCategoryDescription)
+	}
+	if c.ParentID != nil && *c.ParentID == c.ID {
+		return invalid("parent_id", "a category cannot be its own parent")
+	}
+	return nil
+}
+
+func (s *CategoryService) GetByID(id int, withStats bool) (*models.Category, error) {
+	return s.repo.GetByID(id, withStats)
 }
 
+// Update replaces every editable field of the category, provided it is still
+// at category.Version.
 func (s *CategoryService) Update(category *models.Category) error {
+	if err := validateCategory(category); err != nil {
+		return err
+	}
 	return s.repo.Update(category)
 }
 
-func (s *CategoryService) Delete(id int) error {
-	return s.repo.Delete(id)
+// Patch applies a JSON Merge Patch to the category and returns the result.
+// AnyVersion patches whatever version is current.
+func (s *CategoryService) Patch(id, version int, patch []byte) (*models.Category, error) {
+	category, err := s.repo.GetByID(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if version != repositories.AnyVersion && version != category.Version {
+		return nil, repositories.ErrVersionConflict
+	}
+
+	err = mergePatch(patch, map[string]interface{}{
+		"name":        &category.Name,
+		"description": &category.Description,
+		"parent_id":   &category.ParentID,
+	}, map[string]bool{"description": true, "parent_id": true})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Update(category); err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (s *CategoryService) Restore(id int) (*models.Category, error) {
+	if err := s.repo.Restore(id); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(id, false)
+}
+
+func (s *CategoryService) Delete(id int, reassignTo *int, force bool, version int) (int, error) {
+	if reassignTo != nil && force {
+		return 0, invalid("force", "use either reassign_to or force, not both")
+	}
+	if reassignTo != nil && *reassignTo == id {
+		return 0, invalid("reassign_to", "cannot reassign products to the category being deleted")
+	}
+	return s.repo.Delete(id, reassignTo, force, version)
 }
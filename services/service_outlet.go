@@ -0,0 +1,51 @@
+package services
+
+import (
+	"kasir-api/models"
+	"kasir-api/repositories"
+	"strings"
+)
+
+type OutletService struct {
+	repo *repositories.OutletRepository
+}
+
+func NewOutletService(repo *repositories.OutletRepository) *OutletService {
+	return &OutletService{repo: repo}
+}
+
+func (s *OutletService) GetAll() ([]models.Outlet, error) {
+	return s.repo.GetAll()
+}
+
+func (s *OutletService) GetByID(id int) (*models.Outlet, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *OutletService) Create(outlet *models.Outlet) error {
+	if err := validateOutlet(outlet); err != nil {
+		return err
+	}
+	return s.repo.Create(outlet)
+}
+
+func (s *OutletService) Update(outlet *models.Outlet) error {
+	if err := validateOutlet(outlet); err != nil {
+		return err
+	}
+	return s.repo.Update(outlet)
+}
+
+func validateOutlet(outlet *models.Outlet) error {
+	outlet.Name = strings.TrimSpace(outlet.Name)
+	if outlet.Name == "" {
+		return invalid("name", "outlet name is required")
+	}
+
+	outlet.Timezone = strings.TrimSpace(outlet.Timezone)
+	if _, err := NewBusinessDay(outlet.Timezone, outlet.DayCutoverHour); err != nil {
+		return err
+	}
+
+	return nil
+}
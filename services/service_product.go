@@ -1,34 +1,242 @@
 package services
 
 import (
+	"errors"
 	"kasir-api/models"
 	"kasir-api/repositories"
+	"strings"
+	"time"
 )
 
+var ErrSupervisorRequired = errors.New("adjustment exceeds threshold and requires a supervisor")
+
 type ProductService struct {
-	repo *repositories.ProductRepository
+	repo      *repositories.ProductRepository
+	stockRepo *repositories.StockRepository
+
+	// adjustThreshold is the largest absolute adjustment, in base units,
+	// that can be made without a supervisor role.
+	adjustThreshold int
+	// businessDay decides which batches have expired.
+	businessDay BusinessDay
 }
 
-func NewProductService(repo *repositories.ProductRepository) *ProductService {
-	return &ProductService{repo: repo}
+func NewProductService(repo *repositories.ProductRepository, stockRepo *repositories.StockRepository,
+	adjustThreshold int, businessDay BusinessDay) *ProductService {
+	return &ProductService{repo: repo, stockRepo: stockRepo, adjustThreshold: adjustThreshold, businessDay: businessDay}
 }
 
-func (s *ProductService) GetAll(name string) ([]models.Product, error) {
-	return s.repo.GetAll(name)
+const (
+	defaultProductPageSize = 50
+	maxProductPageSize     = 200
+)
+
+func (s *ProductService) GetAll(filter models.ProductFilter) (*models.ProductPage, error) {
+	if filter.Sort == "" {
+		filter.Sort = "id"
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultProductPageSize
+	}
+	if filter.Limit > maxProductPageSize {
+		filter.Limit = maxProductPageSize
+	}
+
+	return s.repo.GetAll(filter)
 }
 
-func (s *ProductService) Create(data *models.Product) error {
-	return s.repo.Create(data)
+func (s *ProductService) Create(data *models.Product, user string) error {
+	// Opening stock and cost are only set on create; afterwards they change
+	// through the stock ledger and goods receipts.
+	if data.Stock < 0 {
+		return invalid("stock", "stock cannot be negative")
+	}
+	if data.CostPrice < 0 {
+		return invalid("cost_price", "cost_price cannot be negative")
+	}
+	if err := validateProduct(data); err != nil {
+		return err
+	}
+
+	return s.repo.Create(data, user)
+}
+
+// validateProduct checks the editable fields of a product, the same way for
+// create, full replacement and partial update.
+func validateProduct(p *models.Product) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return invalid("name", "name is required")
+	}
+	if p.Price <= 0 {
+		return invalid("price", "price must be greater than 0")
+	}
+	if p.MinStock < 0 {
+		return invalid("min_stock", "min_stock cannot be negative")
+	}
+	if p.ReorderQty < 0 {
+		return invalid("reorder_qty", "reorder_qty cannot be negative")
+	}
+	if p.CategoryID != nil && *p.CategoryID <= 0 {
+		return invalid("category_id", "category_id must be greater than 0")
+	}
+	return nil
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+func (s *ProductService) Search(q string, limit int) ([]models.ProductSearchResult, error) {
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	return s.repo.Search(q, limit)
+}
+
+func (s *ProductService) GetLowStock() ([]models.Product, error) {
+	return s.repo.GetLowStock()
 }
 
 func (s *ProductService) GetByID(id int) (*models.Product, error) {
 	return s.repo.GetByID(id)
 }
 
+// Update replaces every editable field of the product, provided it is still
+// at product.Version.
 func (s *ProductService) Update(product *models.Product) error {
+	if err := validateProduct(product); err != nil {
+		return err
+	}
 	return s.repo.Update(product)
 }
 
-func (s *ProductService) Delete(id int) error {
-	return s.repo.Delete(id)
+// Patch applies a JSON Merge Patch to the product's editable fields and
+// returns the updated product. Stock and cost price are read-only here.
+// AnyVersion patches whatever version is current.
+func (s *ProductService) Patch(id, version int, patch []byte) (*models.Product, error) {
+	product, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if version != repositories.AnyVersion && version != product.Version {
+		return nil, repositories.ErrVersionConflict
+	}
+
+	err = mergePatch(patch, map[string]interface{}{
+		"name":          &product.Name,
+		"sku":           &product.SKU,
+		"barcode":       &product.Barcode,
+		"brand":         &product.Brand,
+		"price":         &product.Price,
+		"min_stock":     &product.MinStock,
+		"reorder_qty":   &product.ReorderQty,
+		"track_batches": &product.TrackBatches,
+		"category_id":   &product.CategoryID,
+	}, map[string]bool{"sku": true, "barcode": true, "brand": true, "category_id": true})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Update(product); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetByID(id)
+}
+
+func (s *ProductService) Delete(id, version int) error {
+	return s.repo.Delete(id, version)
+}
+
+func (s *ProductService) Restore(id int) (*models.Product, error) {
+	if err := s.repo.Restore(id); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByID(id)
+}
+
+func (s *ProductService) GetUnits(productID int) ([]models.ProductUnit, error) {
+	return s.repo.GetUnits(productID)
+}
+
+func (s *ProductService) CreateUnit(unit *models.ProductUnit) error {
+	return s.repo.CreateUnit(unit)
+}
+
+func (s *ProductService) DeleteUnit(productID, unitID int) error {
+	return s.repo.DeleteUnit(productID, unitID)
+}
+
+func (s *ProductService) GetStockLedger(productID int) (*models.StockLedger, error) {
+	return s.stockRepo.GetLedger(productID)
+}
+
+func (s *ProductService) AdjustStock(productID int, adj models.StockAdjustment, user, role string) (*models.StockMovement, error) {
+	switch adj.Reason {
+	case models.AdjustmentDamaged, models.AdjustmentLost, models.AdjustmentFound, models.AdjustmentExpired:
+	default:
+		return nil, invalid("reason", "reason must be one of damaged, lost, found, expired")
+	}
+
+	if adj.Delta == 0 {
+		return nil, invalid("delta", "delta cannot be zero")
+	}
+
+	// Stock that turns up can only go in, and stock that is damaged, lost
+	// or expired only out, so totals per reason stay meaningful
+	if adj.Reason == models.AdjustmentFound && adj.Delta < 0 {
+		return nil, invalid("delta", "delta must be positive for reason found")
+	}
+	if adj.Reason != models.AdjustmentFound && adj.Delta > 0 {
+		return nil, invalid("delta", "delta must be negative for reason %s", adj.Reason)
+	}
+
+	delta := adj.Delta
+	if delta < 0 {
+		delta = -delta
+	}
+	if delta > s.adjustThreshold && role != models.RoleSupervisor && role != models.RoleOwner {
+		return nil, ErrSupervisorRequired
+	}
+
+	return s.stockRepo.AdjustStock(productID, adj, user)
+}
+
+func (s *ProductService) GetBatches(productID int) ([]models.ProductBatch, error) {
+	return s.stockRepo.GetBatches(productID, s.today())
+}
+
+func (s *ProductService) CreateBatch(batch *models.ProductBatch) error {
+	if batch.LotNumber == "" {
+		return invalid("lot_number", "lot_number is required")
+	}
+	if batch.Quantity <= 0 {
+		return invalid("quantity", "quantity must be greater than 0")
+	}
+	if batch.ExpiryDate != nil && !validDate(*batch.ExpiryDate) {
+		return invalid("expiry_date", "expiry_date must be formatted YYYY-MM-DD")
+	}
+
+	return s.stockRepo.CreateBatch(batch, s.today())
+}
+
+func (s *ProductService) GetExpiringBatches(days int) ([]models.ProductBatch, error) {
+	return s.stockRepo.GetExpiringBatches(days, s.today())
+}
+
+// today is the store's business date, YYYY-MM-DD.
+func (s *ProductService) today() string {
+	return s.businessDay.Date(time.Now()).Format("2006-01-02")
+}
+
+func validDate(value string) bool {
+	_, err := time.Parse("2006-01-02", value)
+	return err == nil
 }
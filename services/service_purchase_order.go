@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"kasir-api/models"
+	"kasir-api/repositories"
+)
+
+type PurchaseOrderService struct {
+	repo *repositories.PurchaseOrderRepository
+}
+
+func NewPurchaseOrderService(repo *repositories.PurchaseOrderRepository) *PurchaseOrderService {
+	return &PurchaseOrderService{repo: repo}
+}
+
+func (s *PurchaseOrderService) GetAll(status string) ([]models.PurchaseOrder, error) {
+	return s.repo.GetAll(status)
+}
+
+func (s *PurchaseOrderService) GetByID(id int) (*models.PurchaseOrder, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *PurchaseOrderService) Create(po *models.PurchaseOrder) error {
+	if err := validatePurchaseOrder(po); err != nil {
+		return err
+	}
+	return s.repo.Create(po)
+}
+
+func (s *PurchaseOrderService) Update(po *models.PurchaseOrder) error {
+	if err := validatePurchaseOrder(po); err != nil {
+		return err
+	}
+	return s.repo.UpdateDraft(po)
+}
+
+func (s *PurchaseOrderService) Send(id int) error {
+	return s.repo.SetStatus(id, models.POStatusSent, models.POStatusDraft)
+}
+
+// Cancel is only possible before anything was received; a partially received
+// order is closed by receiving with close set instead.
+func (s *PurchaseOrderService) Cancel(id int) error {
+	return s.repo.SetStatus(id, models.POStatusCancelled, models.POStatusDraft, models.POStatusSent)
+}
+
+func (s *PurchaseOrderService) Receive(id int, req models.GoodsReceiptRequest, user string) (*models.GoodsReceipt, error) {
+	if len(req.Lines) == 0 {
+		return nil, errors.New("lines cannot be empty")
+	}
+
+	for _, line := range req.Lines {
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("quantity for line id %d must be greater than 0", line.LineID)
+		}
+		if line.ExpiryDate != nil && !validDate(*line.ExpiryDate) {
+			return nil, fmt.Errorf("expiry_date for line id %d must be formatted YYYY-MM-DD", line.LineID)
+		}
+	}
+
+	return s.repo.Receive(id, req, user)
+}
+
+func validatePurchaseOrder(po *models.PurchaseOrder) error {
+	if po.SupplierID <= 0 {
+		return errors.New("supplier_id is required")
+	}
+
+	if len(po.Lines) == 0 {
+		return errors.New("lines cannot be empty")
+	}
+
+	for _, line := range po.Lines {
+		if line.ProductID <= 0 {
+			return errors.New("product_id is required on every line")
+		}
+		if line.Quantity <= 0 {
+			return fmt.Errorf("quantity for product id %d must be greater than 0", line.ProductID)
+		}
+		if line.CostPrice < 0 {
+			return fmt.Errorf("cost_price for product id %d cannot be negative", line.ProductID)
+		}
+	}
+
+	return nil
+}
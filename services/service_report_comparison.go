@@ -0,0 +1,124 @@
+package services
+
+import (
+	"kasir-api/models"
+	"math"
+	"time"
+)
+
+// comparedTopProducts is how many of the period's best sellers a comparison
+// follows.
+const comparedTopProducts = 5
+
+// comparisonRange is the period rng is compared with. For "previous" a range
+// longer than a week that starts on the 1st moves back by calendar months
+// (September compares with August, 1–19 October with 1–19 September); any
+// other range, days and weeks included, moves back by its own number of days.
+// "last_year" takes the same dates a year earlier. Only the dates decide, so
+// a preset and the same dates given explicitly compare alike. Times of day
+// carry over, so a datetime range compares with the same hours.
+func comparisonRange(rng models.ReportRange, compare string) models.ReportRange {
+	start, _ := time.Parse("2006-01-02", rng.StartDate)
+	end, _ := time.Parse("2006-01-02", rng.EndDate)
+
+	days := int(end.Sub(start).Hours()/24) + 1
+
+	var shift func(time.Time) time.Time
+	switch {
+	case compare == "last_year":
+		shift = func(t time.Time) time.Time { return shiftMonths(t, 12) }
+	case start.Day() == 1 && days > 7:
+		months := (end.Year()-start.Year())*12 + int(end.Month()-start.Month()) + 1
+		shift = func(t time.Time) time.Time {
+			// a month's last day maps to the earlier month's last day
+			if isLastOfMonth(t) {
+				return shiftMonths(t.AddDate(0, 0, 1), months).AddDate(0, 0, -1)
+			}
+			return shiftMonths(t, months)
+		}
+	default:
+		shift = func(t time.Time) time.Time { return t.AddDate(0, 0, -days) }
+	}
+
+	prevStart, prevEnd := shift(start), shift(end)
+
+	prev := rng
+	prev.StartDate = prevStart.Format("2006-01-02")
+	prev.EndDate = prevEnd.Format("2006-01-02")
+	prev.From = rng.From.AddDate(0, 0, daysBetween(start, prevStart))
+	prev.To = rng.To.AddDate(0, 0, daysBetween(end, prevEnd))
+	return prev
+}
+
+// shiftMonths moves date back by months, keeping the day where the earlier
+// month has it: 29 February becomes the 28th rather than 1 March.
+func shiftMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()-time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(date.Day(), last)-1)
+}
+
+func isLastOfMonth(date time.Time) bool {
+	return date.AddDate(0, 0, 1).Day() == 1
+}
+
+// daysBetween counts the calendar days from one date to another.
+func daysBetween(from, to time.Time) int {
+	return int(math.Round(to.Sub(from).Hours() / 24))
+}
+
+func (s *TransactionService) compareReport(report *models.SalesReport, rng models.ReportRange, compare string) (*models.SalesComparison, error) {
+	prevRange := comparisonRange(rng, compare)
+
+	prev, err := s.repo.GetReport(prevRange)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &models.SalesComparison{
+		Compare:       compare,
+		StartDate:     prevRange.StartDate,
+		EndDate:       prevRange.EndDate,
+		Revenue:       change(report.TotalRevenue, prev.TotalRevenue),
+		Transactions:  change(report.TotalTransaksi, prev.TotalTransaksi),
+		AverageBasket: change(report.AverageBasket, prev.AverageBasket),
+		TopProducts:   make([]models.ProductChange, 0, comparedTopProducts),
+	}
+
+	top, err := s.repo.GetTopSellers(rng, comparedTopProducts)
+	if err != nil {
+		return nil, err
+	}
+
+	prevSales, err := s.repo.GetProductProfit(prevRange)
+	if err != nil {
+		return nil, err
+	}
+	prevByProduct := make(map[int]models.ProductProfit, len(prevSales))
+	for _, p := range prevSales {
+		prevByProduct[p.ProductID] = p
+	}
+
+	for _, p := range top {
+		before := prevByProduct[p.ProductID]
+		comparison.TopProducts = append(comparison.TopProducts, models.ProductChange{
+			ProductID:   p.ProductID,
+			ProductName: p.ProductName,
+			QtySold:     change(p.QtySold, before.QtySold),
+			Revenue:     change(p.Revenue, before.Revenue),
+		})
+	}
+
+	return comparison, nil
+}
+
+// change compares current with previous; the percentage is rounded to two
+// decimals.
+func change(current, previous int) models.Change {
+	c := models.Change{Current: current, Previous: previous, Delta: current - previous}
+	if previous != 0 {
+		percent := math.Round(float64(c.Delta)/math.Abs(float64(previous))*10000) / 100
+		c.Percent = &percent
+	}
+	return c
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"kasir-api/models"
+)
+
+func TestComparisonRange(t *testing.T) {
+	// Monday 19 October 2026, 10:00 WIB
+	now := mustTime(t, "2026-10-19T03:00:00Z")
+	s := &TransactionService{
+		businessDay:  mustBusinessDay(t, "Asia/Jakarta", 0),
+		maxRangeDays: 366,
+		now:          func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name      string
+		query     models.ReportQuery
+		compare   string
+		wantStart string
+		wantEnd   string
+		wantFrom  string
+		wantTo    string
+	}{
+		{name: "last_month is the month before", query: models.ReportQuery{Period: "last_month"}, compare: "previous",
+			wantStart: "2026-08-01", wantEnd: "2026-08-31",
+			wantFrom: "2026-07-31T17:00:00Z", wantTo: "2026-08-31T17:00:00Z"},
+		{name: "this_month so far", query: models.ReportQuery{Period: "this_month"}, compare: "previous",
+			wantStart: "2026-09-01", wantEnd: "2026-09-19",
+			wantFrom: "2026-08-31T17:00:00Z", wantTo: "2026-09-19T17:00:00Z"},
+		{name: "March compares with a leap February", query: models.ReportQuery{StartDate: "2024-03-01", EndDate: "2024-03-31"},
+			compare: "previous", wantStart: "2024-02-01", wantEnd: "2024-02-29"},
+		{name: "a quarter", query: models.ReportQuery{StartDate: "2026-07-01", EndDate: "2026-09-30"}, compare: "previous",
+			wantStart: "2026-04-01", wantEnd: "2026-06-30"},
+		{name: "January reaches into last year", query: models.ReportQuery{StartDate: "2026-01-01", EndDate: "2026-01-31"},
+			compare: "previous", wantStart: "2025-12-01", wantEnd: "2025-12-31"},
+		{name: "last_week", query: models.ReportQuery{Period: "last_week"}, compare: "previous",
+			wantStart: "2026-10-05", wantEnd: "2026-10-11"},
+		{name: "today", query: models.ReportQuery{Period: "today"}, compare: "previous",
+			wantStart: "2026-10-18", wantEnd: "2026-10-18",
+			wantFrom: "2026-10-17T17:00:00Z", wantTo: "2026-10-18T17:00:00Z"},
+		{name: "the same dates as this_month", query: models.ReportQuery{StartDate: "2026-10-01", EndDate: "2026-10-19"},
+			compare: "previous", wantStart: "2026-09-01", wantEnd: "2026-09-19",
+			wantFrom: "2026-08-31T17:00:00Z", wantTo: "2026-09-19T17:00:00Z"},
+		{name: "ytd moves by its months", query: models.ReportQuery{Period: "ytd"}, compare: "previous",
+			wantStart: "2025-03-01", wantEnd: "2025-12-19"},
+		{name: "from the 1st into the next month", query: models.ReportQuery{StartDate: "2026-09-01", EndDate: "2026-10-10"},
+			compare: "previous", wantStart: "2026-07-01", wantEnd: "2026-08-10"},
+		{name: "first week of a month moves by days", query: models.ReportQuery{StartDate: "2026-06-01", EndDate: "2026-06-07"},
+			compare: "previous", wantStart: "2026-05-25", wantEnd: "2026-05-31"},
+		{name: "the 1st alone is compared with the day before", query: models.ReportQuery{StartDate: "2026-10-01", EndDate: "2026-10-01"},
+			compare: "previous", wantStart: "2026-09-30", wantEnd: "2026-09-30"},
+		{name: "datetimes keep their hours",
+			query:     models.ReportQuery{StartDate: "2026-10-01T08:00", EndDate: "2026-10-01T17:00"},
+			compare:   "previous",
+			wantStart: "2026-09-30", wantEnd: "2026-09-30",
+			wantFrom: "2026-09-30T01:00:00Z", wantTo: "2026-09-30T10:00:00Z"},
+
+		{name: "last_year", query: models.ReportQuery{Period: "this_month"}, compare: "last_year",
+			wantStart: "2025-10-01", wantEnd: "2025-10-19",
+			wantFrom: "2025-09-30T17:00:00Z", wantTo: "2025-10-19T17:00:00Z"},
+		{name: "last_year from 29 February", query: models.ReportQuery{StartDate: "2024-02-29", EndDate: "2024-03-01"},
+			compare: "last_year", wantStart: "2023-02-28", wantEnd: "2023-03-01",
+			wantFrom: "2023-02-27T17:00:00Z", wantTo: "2023-03-01T17:00:00Z"},
+		{name: "last_year keeps 28 February", query: models.ReportQuery{StartDate: "2025-02-28", EndDate: "2025-02-28"},
+			compare: "last_year", wantStart: "2024-02-28", wantEnd: "2024-02-28"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng, err := s.reportRange(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prev := comparisonRange(rng, tt.compare)
+			if prev.StartDate != tt.wantStart || prev.EndDate != tt.wantEnd {
+				t.Errorf("dates = %s..%s, want %s..%s", prev.StartDate, prev.EndDate, tt.wantStart, tt.wantEnd)
+			}
+			if tt.wantFrom != "" && !prev.From.Equal(mustTime(t, tt.wantFrom)) {
+				t.Errorf("From = %s, want %s", prev.From.UTC(), tt.wantFrom)
+			}
+			if tt.wantTo != "" && !prev.To.Equal(mustTime(t, tt.wantTo)) {
+				t.Errorf("To = %s, want %s", prev.To.UTC(), tt.wantTo)
+			}
+		})
+	}
+}
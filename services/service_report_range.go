@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"kasir-api/models"
+	"kasir-api/repositories"
+	"strings"
+	"time"
+)
+
+// reportPresets are the named periods a report can ask for, each ending today
+// or before it, given the current business date.
+var reportPresets = map[string]func(today time.Time) (start, end time.Time){
+	"today": func(today time.Time) (time.Time, time.Time) {
+		return today, today
+	},
+	"yesterday": func(today time.Time) (time.Time, time.Time) {
+		yesterday := today.AddDate(0, 0, -1)
+		return yesterday, yesterday
+	},
+	"this_week": func(today time.Time) (time.Time, time.Time) {
+		return startOfWeek(today), today
+	},
+	"last_week": func(today time.Time) (time.Time, time.Time) {
+		start := startOfWeek(today).AddDate(0, 0, -7)
+		return start, start.AddDate(0, 0, 6)
+	},
+	"this_month": func(today time.Time) (time.Time, time.Time) {
+		return today.AddDate(0, 0, 1-today.Day()), today
+	},
+	"last_month": func(today time.Time) (time.Time, time.Time) {
+		end := today.AddDate(0, 0, -today.Day())
+		return end.AddDate(0, 0, 1-end.Day()), end
+	},
+	"ytd": func(today time.Time) (time.Time, time.Time) {
+		return time.Date(today.Year(), 1, 1, 0, 0, 0, 0, time.UTC), today
+	},
+}
+
+const reportPresetNames = "today, yesterday, this_week, last_week, this_month, last_month, ytd"
+
+// startOfWeek is the Monday of date's week.
+func startOfWeek(date time.Time) time.Time {
+	return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+}
+
+// reportTime is one end of a requested range: a business date, or an exact
+// instant when the request gave a datetime.
+type reportTime struct {
+	date    time.Time
+	instant *time.Time
+}
+
+// parseReportTime accepts YYYY-MM-DD, or a datetime with or without an
+// offset; without one it is read in the business day's timezone.
+func parseReportTime(field, value string, day BusinessDay) (reportTime, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return reportTime{date: date}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+			if t, err = time.ParseInLocation(layout, value, day.Location); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return reportTime{}, invalid(field, "%s %q is neither a date (YYYY-MM-DD) nor a datetime (e.g. 2006-01-02T15:04:05+07:00)",
+			field, value)
+	}
+
+	return reportTime{date: day.Date(t), instant: &t}, nil
+}
+
+// reportRange resolves a report query to the time it spans at the outlet, or
+// at the store when no outlet is given. Dates are whole business days, end
+// date included; a datetime is the exact moment the range starts or stops.
+func (s *TransactionService) reportRange(q models.ReportQuery) (models.ReportRange, error) {
+	day, err := s.outletBusinessDay(q.OutletID)
+	if errors.Is(err, repositories.ErrOutletNotFound) {
+		return models.ReportRange{}, invalid("outlet_id", "outlet id %d not found", *q.OutletID)
+	}
+	if err != nil {
+		return models.ReportRange{}, err
+	}
+
+	today := day.Date(s.now())
+	start, end := reportTime{date: today}, reportTime{date: today}
+
+	switch {
+	case q.Period != "":
+		if q.StartDate != "" || q.EndDate != "" {
+			return models.ReportRange{}, invalid("period", "give either period or start_date and end_date, not both")
+		}
+		preset, ok := reportPresets[strings.ToLower(q.Period)]
+		if !ok {
+			return models.ReportRange{}, invalid("period", "unknown period %q; use one of %s", q.Period, reportPresetNames)
+		}
+		start.date, end.date = preset(today)
+
+	case q.StartDate == "" && q.EndDate != "":
+		return models.ReportRange{}, invalid("start_date", "start_date is required when end_date is given")
+	case q.StartDate != "" && q.EndDate == "":
+		return models.ReportRange{}, invalid("end_date", "end_date is required when start_date is given")
+
+	case q.StartDate != "":
+		if start, err = parseReportTime("start_date", q.StartDate, day); err != nil {
+			return models.ReportRange{}, err
+		}
+		if end, err = parseReportTime("end_date", q.EndDate, day); err != nil {
+			return models.ReportRange{}, err
+		}
+	}
+
+	rng := models.ReportRange{
+		StartDate:   start.date.Format("2006-01-02"),
+		EndDate:     end.date.Format("2006-01-02"),
+		From:        day.Start(start.date),
+		To:          day.Start(end.date.AddDate(0, 0, 1)),
+		OutletID:    q.OutletID,
+		Timezone:    day.Location.String(),
+		CutoverHour: day.CutoverHour,
+	}
+
+	if start.instant != nil {
+		rng.From = *start.instant
+	}
+	if end.instant != nil {
+		rng.To = *end.instant
+	}
+	if rng.To.Before(rng.From) || end.date.Before(start.date) {
+		return models.ReportRange{}, invalid("end_date", "end_date %s is before start_date %s", q.EndDate, q.StartDate)
+	}
+
+	if days := int(end.date.Sub(start.date).Hours()/24) + 1; s.maxRangeDays > 0 && days > s.maxRangeDays {
+		return models.ReportRange{}, invalid("end_date", "the range covers %d days; reports may cover at most %d",
+			days, s.maxRangeDays)
+	}
+
+	return rng, nil
+}
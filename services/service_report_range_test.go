@@ -0,0 +1,134 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"kasir-api/models"
+)
+
+func TestReportRange(t *testing.T) {
+	// Monday 19 October 2026, 10:00 WIB
+	monday := "2026-10-19T03:00:00Z"
+
+	tests := []struct {
+		name      string
+		now       string
+		timezone  string
+		cutover   int
+		query     models.ReportQuery
+		wantStart string
+		wantEnd   string
+		wantFrom  string
+		wantTo    string
+		wantField string
+	}{
+		{name: "empty is today", query: models.ReportQuery{},
+			wantStart: "2026-10-19", wantEnd: "2026-10-19",
+			wantFrom: "2026-10-18T17:00:00Z", wantTo: "2026-10-19T17:00:00Z"},
+		{name: "today", query: models.ReportQuery{Period: "today"},
+			wantStart: "2026-10-19", wantEnd: "2026-10-19"},
+		{name: "yesterday", query: models.ReportQuery{Period: "yesterday"},
+			wantStart: "2026-10-18", wantEnd: "2026-10-18"},
+		{name: "this_week on a Monday", query: models.ReportQuery{Period: "this_week"},
+			wantStart: "2026-10-19", wantEnd: "2026-10-19"},
+		{name: "this_week on a Sunday", now: "2026-10-25T03:00:00Z", query: models.ReportQuery{Period: "this_week"},
+			wantStart: "2026-10-19", wantEnd: "2026-10-25"},
+		{name: "last_week", query: models.ReportQuery{Period: "last_week"},
+			wantStart: "2026-10-12", wantEnd: "2026-10-18"},
+		{name: "this_month", query: models.ReportQuery{Period: "this_month"},
+			wantStart: "2026-10-01", wantEnd: "2026-10-19"},
+		{name: "last_month", query: models.ReportQuery{Period: "last_month"},
+			wantStart: "2026-09-01", wantEnd: "2026-09-30"},
+		{name: "last_month in March", now: "2024-03-10T03:00:00Z", query: models.ReportQuery{Period: "last_month"},
+			wantStart: "2024-02-01", wantEnd: "2024-02-29"},
+		{name: "ytd", query: models.ReportQuery{Period: "ytd"},
+			wantStart: "2026-01-01", wantEnd: "2026-10-19"},
+		{name: "presets ignore case", query: models.ReportQuery{Period: "This_Month"},
+			wantStart: "2026-10-01", wantEnd: "2026-10-19"},
+		{name: "UTC evening is already tomorrow in WIB", now: "2026-10-19T18:00:00Z", query: models.ReportQuery{},
+			wantStart: "2026-10-20", wantEnd: "2026-10-20"},
+
+		{name: "business day after cutover", now: "2026-10-19T19:30:00Z", timezone: "Asia/Makassar", cutover: 4,
+			query:     models.ReportQuery{},
+			wantStart: "2026-10-19", wantEnd: "2026-10-19",
+			wantFrom: "2026-10-18T20:00:00Z", wantTo: "2026-10-19T20:00:00Z"},
+
+		{name: "dates", query: models.ReportQuery{StartDate: "2026-10-01", EndDate: "2026-10-05"},
+			wantStart: "2026-10-01", wantEnd: "2026-10-05",
+			wantFrom: "2026-09-30T17:00:00Z", wantTo: "2026-10-05T17:00:00Z"},
+		{name: "datetimes in the store's zone and with an offset",
+			query:     models.ReportQuery{StartDate: "2026-10-01T08:00", EndDate: "2026-10-01T17:00:00+07:00"},
+			wantStart: "2026-10-01", wantEnd: "2026-10-01",
+			wantFrom: "2026-10-01T01:00:00Z", wantTo: "2026-10-01T10:00:00Z"},
+		{name: "datetime start, date end",
+			query:     models.ReportQuery{StartDate: "2026-10-01T12:00:00Z", EndDate: "2026-10-02"},
+			wantStart: "2026-10-01", wantEnd: "2026-10-02",
+			wantFrom: "2026-10-01T12:00:00Z", wantTo: "2026-10-02T17:00:00Z"},
+		{name: "same day", query: models.ReportQuery{StartDate: "2026-10-01", EndDate: "2026-10-01"},
+			wantStart: "2026-10-01", wantEnd: "2026-10-01"},
+		{name: "a leap year is within 366 days", query: models.ReportQuery{StartDate: "2024-01-01", EndDate: "2024-12-31"},
+			wantStart: "2024-01-01", wantEnd: "2024-12-31"},
+
+		{name: "only start", query: models.ReportQuery{StartDate: "2026-10-01"}, wantField: "end_date"},
+		{name: "only end", query: models.ReportQuery{EndDate: "2026-10-01"}, wantField: "start_date"},
+		{name: "end before start", query: models.ReportQuery{StartDate: "2026-10-05", EndDate: "2026-10-01"},
+			wantField: "end_date"},
+		{name: "end datetime before start datetime",
+			query:     models.ReportQuery{StartDate: "2026-10-01T10:00:00Z", EndDate: "2026-10-01T09:00:00Z"},
+			wantField: "end_date"},
+		{name: "malformed start", query: models.ReportQuery{StartDate: "2026-13-01", EndDate: "2026-10-01"},
+			wantField: "start_date"},
+		{name: "malformed end", query: models.ReportQuery{StartDate: "2026-10-01", EndDate: "tomorrow"},
+			wantField: "end_date"},
+		{name: "too long", query: models.ReportQuery{StartDate: "2025-01-01", EndDate: "2026-01-02"},
+			wantField: "end_date"},
+		{name: "unknown period", query: models.ReportQuery{Period: "fortnight"}, wantField: "period"},
+		{name: "period and dates", query: models.ReportQuery{Period: "today", StartDate: "2026-10-01"},
+			wantField: "period"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.now == "" {
+				tt.now = monday
+			}
+			if tt.timezone == "" {
+				tt.timezone = "Asia/Jakarta"
+			}
+			now := mustTime(t, tt.now)
+			s := &TransactionService{
+				businessDay:  mustBusinessDay(t, tt.timezone, tt.cutover),
+				maxRangeDays: 366,
+				now:          func() time.Time { return now },
+			}
+
+			rng, err := s.reportRange(tt.query)
+			if field := validationField(err); field != tt.wantField {
+				t.Fatalf("error = %v, want field %q", err, tt.wantField)
+			}
+			if tt.wantField != "" {
+				return
+			}
+
+			if rng.StartDate != tt.wantStart || rng.EndDate != tt.wantEnd {
+				t.Errorf("dates = %s..%s, want %s..%s", rng.StartDate, rng.EndDate, tt.wantStart, tt.wantEnd)
+			}
+			if tt.wantFrom != "" && !rng.From.Equal(mustTime(t, tt.wantFrom)) {
+				t.Errorf("From = %s, want %s", rng.From.UTC(), tt.wantFrom)
+			}
+			if tt.wantTo != "" && !rng.To.Equal(mustTime(t, tt.wantTo)) {
+				t.Errorf("To = %s, want %s", rng.To.UTC(), tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestReportRangeWithoutLimit(t *testing.T) {
+	s := &TransactionService{businessDay: mustBusinessDay(t, "Asia/Jakarta", 0), now: time.Now}
+
+	_, err := s.reportRange(models.ReportQuery{StartDate: "2020-01-01", EndDate: "2026-01-01"})
+	if err != nil {
+		t.Errorf("error = %v, want none when maxRangeDays is 0", err)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"kasir-api/models"
+	"kasir-api/notifier"
+	"kasir-api/repositories"
+)
+
+// StockWatcher is told which products just lost stock so it can check them.
+type StockWatcher interface {
+	Watch(productIDs ...int)
+}
+
+// LowStockChecker runs in the background and alerts when products drop to
+// or below their minimum stock. Checkout hands it the products it touched;
+// a periodic sweep catches every other path, and re-arms products whose
+// minimum was lowered below their stock.
+//
+// An alert that no notifier delivered is released to be claimed again. One
+// that only some delivered stays claimed, and the sweep retries just the
+// notifiers that failed, so the others do not repeat it.
+type LowStockChecker struct {
+	repo     *repositories.ProductRepository
+	notifier notifier.Notifier
+	interval time.Duration
+	queue    chan []int
+
+	// pending is owned by Run's goroutine.
+	pending map[int]pendingAlert
+}
+
+// pendingAlert is an alert still owed to some notifiers.
+type pendingAlert struct {
+	alert models.LowStockAlert
+	sinks notifier.Notifier
+}
+
+func NewLowStockChecker(repo *repositories.ProductRepository, n notifier.Notifier, interval time.Duration) *LowStockChecker {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	return &LowStockChecker{
+		repo:     repo,
+		notifier: n,
+		interval: interval,
+		queue:    make(chan []int, 256),
+		pending:  make(map[int]pendingAlert),
+	}
+}
+
+// Watch queues products for checking without blocking the caller. If the
+// queue is full the next sweep picks them up instead.
+func (c *LowStockChecker) Watch(productIDs ...int) {
+	if len(productIDs) == 0 {
+		return
+	}
+
+	select {
+	case c.queue <- productIDs:
+	default:
+	}
+}
+
+// Run processes checks until ctx is cancelled.
+func (c *LowStockChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.sweep()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ids := <-c.queue:
+			c.check(ids)
+		case <-ticker.C:
+			c.sweep()
+		}
+	}
+}
+
+func (c *LowStockChecker) sweep() {
+	recovered, err := c.repo.ResetRecoveredLowStock()
+	if err != nil {
+		log.Printf("low stock reset error: %v", err)
+	}
+	for _, id := range recovered {
+		delete(c.pending, id)
+	}
+
+	c.retryPending()
+	c.check(nil)
+}
+
+func (c *LowStockChecker) check(ids []int) {
+	alerts, err := c.repo.ClaimLowStockAlerts(ids)
+	if err != nil {
+		log.Printf("low stock check error: %v", err)
+		return
+	}
+
+	for _, alert := range alerts {
+		if !c.deliver(alert, c.notifier) {
+			if err := c.repo.ReleaseLowStockAlert(alert.ProductID); err != nil {
+				log.Printf("low stock release error: %v", err)
+			}
+		}
+	}
+}
+
+// retryPending sends pending alerts again to the notifiers that missed them.
+func (c *LowStockChecker) retryPending() {
+	for _, p := range c.pending {
+		c.deliver(p.alert, p.sinks)
+	}
+}
+
+// deliver sends alert through sinks and reports whether any of them got it.
+// Sinks that failed while others delivered are kept in pending.
+func (c *LowStockChecker) deliver(alert models.LowStockAlert, sinks notifier.Notifier) bool {
+	err := sinks.NotifyLowStock(alert)
+	if err == nil {
+		delete(c.pending, alert.ProductID)
+		return true
+	}
+	log.Printf("low stock notify error for product %d: %v", alert.ProductID, err)
+
+	var partial *notifier.PartialError
+	if errors.As(err, &partial) {
+		c.pending[alert.ProductID] = pendingAlert{alert: alert, sinks: partial.Failed}
+		return true
+	}
+	return false
+}
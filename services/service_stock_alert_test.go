@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"kasir-api/models"
+	"kasir-api/notifier"
+)
+
+// fakeSink records the alerts it is sent and fails while down is set.
+type fakeSink struct {
+	down bool
+	sent []int
+}
+
+func (f *fakeSink) NotifyLowStock(alert models.LowStockAlert) error {
+	if f.down {
+		return errors.New("sink unreachable")
+	}
+	f.sent = append(f.sent, alert.ProductID)
+	return nil
+}
+
+func TestLowStockDeliverRetriesOnlyFailedSinks(t *testing.T) {
+	logSink := &fakeSink{}
+	webhook := &fakeSink{down: true}
+	c := &LowStockChecker{
+		notifier: notifier.Multi{logSink, webhook},
+		pending:  make(map[int]pendingAlert),
+	}
+	alert := models.LowStockAlert{ProductID: 7, ProductName: "Indomie", Stock: 2, MinStock: 5}
+
+	if !c.deliver(alert, c.notifier) {
+		t.Fatal("deliver = false with one sink delivering, want the claim kept")
+	}
+	if _, ok := c.pending[7]; !ok {
+		t.Fatal("alert not pending for the failed sink")
+	}
+
+	// Still down: the alert stays pending and the log is not repeated
+	c.retryPending()
+	if len(logSink.sent) != 1 || len(webhook.sent) != 0 {
+		t.Fatalf("sent log=%v webhook=%v, want log once and webhook never", logSink.sent, webhook.sent)
+	}
+	if _, ok := c.pending[7]; !ok {
+		t.Fatal("alert dropped while the webhook is still down")
+	}
+
+	webhook.down = false
+	c.retryPending()
+	if len(logSink.sent) != 1 || len(webhook.sent) != 1 {
+		t.Fatalf("sent log=%v webhook=%v, want each once", logSink.sent, webhook.sent)
+	}
+	if len(c.pending) != 0 {
+		t.Fatalf("pending = %v after delivery, want empty", c.pending)
+	}
+}
+
+func TestLowStockDeliverAllSinksFailing(t *testing.T) {
+	c := &LowStockChecker{
+		notifier: notifier.Multi{&fakeSink{down: true}, &fakeSink{down: true}},
+		pending:  make(map[int]pendingAlert),
+	}
+
+	if c.deliver(models.LowStockAlert{ProductID: 7}, c.notifier) {
+		t.Error("deliver = true with every sink failing, want the claim released")
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("pending = %v, want empty when nothing was delivered", c.pending)
+	}
+}
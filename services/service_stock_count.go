@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"kasir-api/models"
+	"kasir-api/repositories"
+)
+
+type StockCountService struct {
+	repo *repositories.StockCountRepository
+}
+
+func NewStockCountService(repo *repositories.StockCountRepository) *StockCountService {
+	return &StockCountService{repo: repo}
+}
+
+func (s *StockCountService) Start(count *models.StockCount) error {
+	return s.repo.Start(count)
+}
+
+func (s *StockCountService) GetAll() ([]models.StockCount, error) {
+	return s.repo.GetAll()
+}
+
+func (s *StockCountService) GetByID(id int) (*models.StockCount, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *StockCountService) SubmitItems(id int, items []models.StockCountItem, user string) error {
+	if len(items) == 0 {
+		return errors.New("items cannot be empty")
+	}
+
+	for _, item := range items {
+		if item.Counted < 0 {
+			return errors.New("counted quantity cannot be negative")
+		}
+	}
+
+	return s.repo.SubmitItems(id, items, user)
+}
+
+func (s *StockCountService) Post(id int, user string) (*models.StockCount, error) {
+	return s.repo.Post(id, user)
+}
+
+func (s *StockCountService) Cancel(id int) error {
+	return s.repo.Cancel(id)
+}
@@ -3,24 +3,184 @@ package services
 import (
 	"kasir-api/models"
 	"kasir-api/repositories"
+	"time"
 )
 
 type TransactionService struct {
-	repo *repositories.TransactionRepository
+	repo       *repositories.TransactionRepository
+	outletRepo *repositories.OutletRepository
+	watcher    StockWatcher
+
+	// businessDay is the store's default, for reports across all outlets.
+	businessDay BusinessDay
+	// maxRangeDays caps how many days a report may cover; 0 is no limit.
+	maxRangeDays int
+	// now tells the time, for the business date of today.
+	now func() time.Time
+}
+
+func NewTransactionService(repo *repositories.TransactionRepository, outletRepo *repositories.OutletRepository,
+	watcher StockWatcher, businessDay BusinessDay, maxRangeDays int) *TransactionService {
+	return &TransactionService{repo: repo, outletRepo: outletRepo, watcher: watcher,
+		businessDay: businessDay, maxRangeDays: maxRangeDays, now: time.Now}
+}
+
+func (s *TransactionService) Checkout(items []models.CheckoutItem, outletID *int, user string) (*models.Transaction, error) {
+	day, err := s.outletBusinessDay(outletID)
+	if err != nil {
+		return nil, err
+	}
+
+	today := day.Date(s.now()).Format("2006-01-02")
+	transaction, err := s.repo.CreateTransaction(items, outletID, today, user)
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]int, 0, len(transaction.Details))
+	for _, d := range transaction.Details {
+		productIDs = append(productIDs, d.ProductID)
+	}
+	s.watcher.Watch(productIDs...)
+
+	return transaction, nil
+}
+
+// outletBusinessDay is the business day of the outlet, or the store's when
+// outletID is nil.
+func (s *TransactionService) outletBusinessDay(outletID *int) (BusinessDay, error) {
+	if outletID == nil {
+		return s.businessDay, nil
+	}
+
+	outlet, err := s.outletRepo.GetByID(*outletID)
+	if err != nil {
+		return BusinessDay{}, err
+	}
+	return NewBusinessDay(outlet.Timezone, outlet.DayCutoverHour)
+}
+
+func (s *TransactionService) GetTodayReport(outletID *int) (*models.SalesReport, error) {
+	return s.GetReport(models.ReportQuery{Period: "today", OutletID: outletID}, "")
+}
+
+// GetReport totals sales over the queried period and, when compare is
+// previous or last_year, sets them against that earlier period.
+func (s *TransactionService) GetReport(q models.ReportQuery, compare string) (*models.SalesReport, error) {
+	switch compare {
+	case "", "previous", "last_year":
+	default:
+		return nil, invalid("compare", "compare must be previous or last_year")
+	}
+
+	rng, err := s.reportRange(q)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := s.repo.GetReport(rng)
+	if err != nil || compare == "" {
+		return report, err
+	}
+
+	report.Comparison, err = s.compareReport(report, rng, compare)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
 }
 
-func NewTransactionService(repo *repositories.TransactionRepository) *TransactionService {
-	return &TransactionService{repo: repo}
+func (s *TransactionService) GetProductProfit(q models.ReportQuery) ([]models.ProductProfit, error) {
+	rng, err := s.reportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetProductProfit(rng)
 }
 
-func (s *TransactionService) Checkout(items []models.CheckoutItem) (*models.Transaction, error) {
-	return s.repo.CreateTransaction(items)
+// requireRange refuses to default reports that make no sense for just today
+// to it.
+func requireRange(q models.ReportQuery) error {
+	if q.Period == "" && q.StartDate == "" && q.EndDate == "" {
+		return invalid("start_date", "give start_date and end_date, or a period such as this_month")
+	}
+	return nil
 }
 
-func (s *TransactionService) GetTodayReport() (*models.SalesReport, error) {
-	return s.repo.GetTodayReport()
+// GetSalesSeries reports sales per period; groupBy is day, week or month.
+func (s *TransactionService) GetSalesSeries(q models.ReportQuery, groupBy string) ([]models.SalesPeriod, error) {
+	if err := requireRange(q); err != nil {
+		return nil, err
+	}
+
+	switch groupBy {
+	case "":
+		groupBy = "day"
+	case "day", "week", "month":
+	default:
+		return nil, invalid("group_by", "group_by must be one of day, week, month")
+	}
+
+	rng, err := s.reportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetSalesSeries(rng, groupBy)
 }
 
-func (s *TransactionService) GetReportByDateRange(startDate, endDate string) (*models.SalesReport, error) {
-	return s.repo.GetReportByDateRange(startDate, endDate)
+const (
+	defaultRankingLimit = 10
+	maxRankingLimit     = 100
+)
+
+// GetProductRanking ranks products by quantity (the default) or revenue.
+func (s *TransactionService) GetProductRanking(q models.ReportQuery, by string, limit int) (*models.ProductRankingReport, error) {
+	switch by {
+	case "":
+		by = "quantity"
+	case "quantity", "revenue":
+	default:
+		return nil, invalid("by", "by must be quantity or revenue")
+	}
+
+	if limit <= 0 {
+		limit = defaultRankingLimit
+	}
+	if limit > maxRankingLimit {
+		limit = maxRankingLimit
+	}
+
+	rng, err := s.reportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetProductRanking(rng, by, limit)
+}
+
+// GetCategorySales reports sales by category, rolled up to the given tree
+// level (0 for none).
+func (s *TransactionService) GetCategorySales(q models.ReportQuery, level int) ([]models.CategorySales, error) {
+	if level < 0 {
+		return nil, invalid("level", "level cannot be negative")
+	}
+
+	rng, err := s.reportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetCategorySales(rng, level)
+}
+
+// GetSalesHeatmap reports sales by day of week and hour of day in the
+// outlet's timezone, or the store's.
+func (s *TransactionService) GetSalesHeatmap(q models.ReportQuery) (*models.SalesHeatmap, error) {
+	if err := requireRange(q); err != nil {
+		return nil, err
+	}
+
+	rng, err := s.reportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetSalesHeatmap(rng)
 }
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ValidationError rejects a request because of what it contains; handlers
+// answer it with 400.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) object to the given
+// fields, keyed by JSON name. Members that are absent stay as they are, null
+// clears a nullable field, and members not listed in fields are refused.
+func mergePatch(patch []byte, fields map[string]interface{}, nullable map[string]bool) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return invalid("", "patch must be a JSON object")
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		target, ok := fields[name]
+		if !ok {
+			return invalid(name, "%s cannot be changed", name)
+		}
+
+		raw := members[name]
+		if bytes.Equal(raw, []byte("null")) {
+			if !nullable[name] {
+				return invalid(name, "%s cannot be null", name)
+			}
+			v := reflect.ValueOf(target).Elem()
+			v.Set(reflect.Zero(v.Type()))
+			continue
+		}
+
+		if err := json.Unmarshal(raw, target); err != nil {
+			return invalid(name, "%s has the wrong type", name)
+		}
+	}
+
+	return nil
+}